@@ -0,0 +1,190 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/register"
+)
+
+var (
+	// make sure register.Register satisfies Register interface.
+	_ Register = (*register.Register)(nil)
+
+	// ErrUnknownRegister is returned when there is no register for a routing key.
+	ErrUnknownRegister = errors.New("unknown register")
+)
+
+type (
+	// Register is a consumer which can be inspected and controlled at runtime.
+	Register interface {
+		Info() register.Info
+		Pause() error
+		Resume() error
+		SetPrefetch(prefetch int) error
+		Drain(ctx context.Context) error
+	}
+
+	// Admin serves the api controlling the registers and querying the users audit log.
+	Admin struct {
+		log       srv.AuditLog
+		keys      []string
+		registers map[string]Register
+	}
+
+	prefetchRequest struct {
+		Prefetch *int `json:"prefetch"`
+	}
+
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+// New returns a new Admin serving the history of log and controlling the given registers,
+// keyed on their routing key.
+func New(log srv.AuditLog, regs ...Register) *Admin {
+	a := &Admin{log: log, registers: make(map[string]Register, len(regs))}
+	for _, r := range regs {
+		key := r.Info().RoutingKey
+		a.keys = append(a.keys, key)
+		a.registers[key] = r
+	}
+
+	return a
+}
+
+// Handler returns the http handler serving the admin endpoints:
+//
+//	GET  /registers                   lists the registers
+//	GET  /registers/{key}             shows a register
+//	POST /registers/{key}/pause       stops consuming messages
+//	POST /registers/{key}/resume      consumes messages again
+//	PUT  /registers/{key}/prefetch    changes the prefetch, e.g. {"prefetch": 10}
+//	POST /drain                       pauses every register and waits for in-flight messages
+//	GET  /users/{id}/history          lists the changes of a user, oldest first
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /registers", a.list)
+	mux.HandleFunc("GET /registers/{key}", a.show)
+	mux.HandleFunc("POST /registers/{key}/pause", a.control(Register.Pause))
+	mux.HandleFunc("POST /registers/{key}/resume", a.control(Register.Resume))
+	mux.HandleFunc("PUT /registers/{key}/prefetch", a.prefetch)
+	mux.HandleFunc("POST /drain", a.drain)
+	mux.HandleFunc("GET /users/{id}/history", a.history)
+
+	return mux
+}
+
+func (a *Admin) list(w http.ResponseWriter, r *http.Request) {
+	infos := make([]register.Info, 0, len(a.keys))
+	for _, key := range a.keys {
+		infos = append(infos, a.registers[key].Info())
+	}
+
+	write(w, http.StatusOK, infos)
+}
+
+func (a *Admin) show(w http.ResponseWriter, r *http.Request) {
+	reg, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	write(w, http.StatusOK, reg.Info())
+}
+
+func (a *Admin) control(fn func(Register) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reg, ok := a.lookup(w, r)
+		if !ok {
+			return
+		}
+		if err := fn(reg); err != nil {
+			write(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+
+		write(w, http.StatusOK, reg.Info())
+	}
+}
+
+func (a *Admin) prefetch(w http.ResponseWriter, r *http.Request) {
+	reg, ok := a.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var req prefetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Prefetch == nil {
+		write(w, http.StatusBadRequest, errorResponse{"expected body {\"prefetch\": n}"})
+		return
+	}
+
+	err := reg.SetPrefetch(*req.Prefetch)
+	switch err {
+	case nil:
+		write(w, http.StatusOK, reg.Info())
+	case register.ErrInvalidPrefetch:
+		write(w, http.StatusBadRequest, errorResponse{err.Error()})
+	default:
+		write(w, http.StatusInternalServerError, errorResponse{err.Error()})
+	}
+}
+
+// drain pauses every register before waiting for any of them, so none keeps consuming while the others drain.
+func (a *Admin) drain(w http.ResponseWriter, r *http.Request) {
+	for _, key := range a.keys {
+		if err := a.registers[key].Pause(); err != nil {
+			write(w, http.StatusServiceUnavailable, errorResponse{key + ": " + err.Error()})
+			return
+		}
+	}
+	for _, key := range a.keys {
+		if err := a.registers[key].Drain(r.Context()); err != nil {
+			write(w, http.StatusServiceUnavailable, errorResponse{key + ": " + err.Error()})
+			return
+		}
+	}
+
+	a.list(w, r)
+}
+
+func (a *Admin) history(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		write(w, http.StatusBadRequest, errorResponse{"invalid user id: " + r.PathValue("id")})
+		return
+	}
+
+	changes, err := a.log.History(uint(id))
+	if err != nil {
+		write(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	if changes == nil {
+		changes = []srv.Change{}
+	}
+
+	write(w, http.StatusOK, changes)
+}
+
+func (a *Admin) lookup(w http.ResponseWriter, r *http.Request) (Register, bool) {
+	key := r.PathValue("key")
+	reg, ok := a.registers[key]
+	if !ok {
+		write(w, http.StatusNotFound, errorResponse{ErrUnknownRegister.Error() + ": " + key})
+	}
+
+	return reg, ok
+}
+
+func write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,231 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/mock"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/message/inmem"
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
+	"github.com/rafaeljesus/srv-consumer/register"
+	storage "github.com/rafaeljesus/srv-consumer/storage/inmem"
+)
+
+func TestAdmin(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *Admin)
+	}{
+		{
+			"list registers",
+			testListRegisters,
+		},
+		{
+			"show unknown register",
+			testShowUnknownRegister,
+		},
+		{
+			"pause and resume register",
+			testPauseAndResumeRegister,
+		},
+		{
+			"set prefetch",
+			testSetPrefetch,
+		},
+		{
+			"drain registers",
+			testDrainRegisters,
+		},
+		{
+			"show user history",
+			testShowUserHistory,
+		},
+		{
+			"fail to show history of invalid user id",
+			testFailToShowHistoryOfInvalidUserID,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			broker := inmem.NewBroker()
+			handler := &mock.Handler{HandleFunc: func(ctx context.Context, m *message.Message) error { return m.Ack(false) }}
+			sts := &mock.Stats{TrackFunc: func(tm time.Time, e stats.Event) {}}
+			logger := slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+
+			var regs []Register
+			for _, key := range []string{"user.created", "user.email.changed"} {
+				reg, err := register.New(key, "users", broker, handler, sts, logger)
+				if err != nil {
+					t.Fatalf("expected to create register: %v", err)
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				go reg.Run(ctx)
+				regs = append(regs, reg)
+			}
+
+			store := storage.New("memory://localhost")
+			cause := srv.Cause{MessageID: "1", RoutingKey: "user.created"}
+			if err := store.WithCause(cause).Add(context.Background(), &srv.User{Username: "foo"}); err != nil {
+				t.Fatalf("expected to add user: %v", err)
+			}
+
+			test.function(t, New(store, regs...))
+		})
+	}
+}
+
+func testListRegisters(t *testing.T, a *Admin) {
+	rec := serve(a, http.MethodGet, "/registers", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	var infos []register.Info
+	if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+		t.Fatalf("expected to decode body: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("unexpected registers: %d", len(infos))
+	}
+	if infos[0].RoutingKey != "user.created" || infos[0].Handler != "mock.Handler" {
+		t.Fatalf("unexpected register: %+v", infos[0])
+	}
+}
+
+func testShowUnknownRegister(t *testing.T, a *Admin) {
+	rec := serve(a, http.MethodGet, "/registers/user.deleted", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+}
+
+func testPauseAndResumeRegister(t *testing.T, a *Admin) {
+	rec := serve(a, http.MethodPost, "/registers/user.created/pause", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"paused":true`) {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+
+	rec = serve(a, http.MethodPost, "/registers/user.created/resume", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"paused":false`) {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+}
+
+func testSetPrefetch(t *testing.T, a *Admin) {
+	rec := serve(a, http.MethodPut, "/registers/user.created/prefetch", `{"prefetch": -1}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	rec = serve(a, http.MethodPut, "/registers/user.created/prefetch", `{}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	rec = serve(a, http.MethodPut, "/registers/user.created/prefetch", `{"prefetch": 5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"prefetch":5`) {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+}
+
+func testDrainRegisters(t *testing.T, a *Admin) {
+	rec := serve(a, http.MethodPost, "/drain", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if strings.Count(rec.Body.String(), `"paused":true`) != 2 {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+}
+
+func testShowUserHistory(t *testing.T, a *Admin) {
+	rec := serve(a, http.MethodGet, "/users/1/history", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	var changes []srv.Change
+	if err := json.NewDecoder(rec.Body).Decode(&changes); err != nil {
+		t.Fatalf("expected to decode body: %v", err)
+	}
+	if len(changes) != 1 || changes[0].MessageID != "1" || changes[0].RoutingKey != "user.created" {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+
+	rec = serve(a, http.MethodGet, "/users/2/history", "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func testFailToShowHistoryOfInvalidUserID(t *testing.T, a *Admin) {
+	rec := serve(a, http.MethodGet, "/users/foo/history", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+}
+
+func serve(a *Admin, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestDrainPausesEveryRegisterFirst(t *testing.T) {
+	var calls []string
+	regs := []Register{
+		&recordingRegister{key: "user.created", calls: &calls},
+		&recordingRegister{key: "user.email.changed", calls: &calls},
+	}
+
+	rec := serve(New(storage.New("memory://localhost"), regs...), http.MethodPost, "/drain", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	want := "pause user.created,pause user.email.changed,drain user.created,drain user.email.changed"
+	if got := strings.Join(calls, ","); got != want {
+		t.Fatalf("unexpected calls: %s", got)
+	}
+}
+
+// recordingRegister records the calls made to it.
+type recordingRegister struct {
+	key   string
+	calls *[]string
+}
+
+func (r *recordingRegister) Info() register.Info { return register.Info{RoutingKey: r.key} }
+
+func (r *recordingRegister) Pause() error {
+	*r.calls = append(*r.calls, "pause "+r.key)
+	return nil
+}
+
+func (r *recordingRegister) Resume() error { return nil }
+
+func (r *recordingRegister) SetPrefetch(prefetch int) error { return nil }
+
+func (r *recordingRegister) Drain(ctx context.Context) error {
+	*r.calls = append(*r.calls, "drain "+r.key)
+	return nil
+}
@@ -0,0 +1,89 @@
+package srv
+
+import "time"
+
+type (
+	// Cause identifies the message which caused a user change.
+	Cause struct {
+		MessageID  string `json:"message_id,omitempty"`
+		RoutingKey string `json:"routing_key,omitempty"`
+	}
+
+	// FieldChange is the change of a single user field, From is empty when the user was added.
+	FieldChange struct {
+		Field string `json:"field"`
+		From  string `json:"from"`
+		To    string `json:"to"`
+	}
+
+	// Change is the audit record appended on every user mutation.
+	Change struct {
+		UserID   uint          `json:"user_id"`
+		Revision uint64        `json:"revision"`
+		Diff     []FieldChange `json:"diff"`
+		Cause
+		Time time.Time `json:"time"`
+	}
+
+	// AuditLog is implemented by stores which record the changes of their users.
+	AuditLog interface {
+		// History returns the changes of the user with the given id, oldest first.
+		History(userID uint) ([]Change, error)
+		// WithCause returns the store recording c as the cause of its mutations.
+		WithCause(c Cause) UserStore
+		// WithCauses returns the store recording causes[i] as the cause of the mutation
+		// of the i-th user of its bulk operations.
+		WithCauses(causes []Cause) UserStore
+	}
+)
+
+// Caused returns the store recording c as the cause of its mutations
+// when it keeps an audit log, otherwise the store itself.
+func Caused(store UserStore, c Cause) UserStore {
+	if log, ok := store.(AuditLog); ok {
+		return log.WithCause(c)
+	}
+
+	return store
+}
+
+// CausedEach returns the store recording causes[i] as the cause of the mutation of the i-th user
+// of its bulk operations when it keeps an audit log, otherwise the store itself.
+func CausedEach(store UserStore, causes []Cause) UserStore {
+	if log, ok := store.(AuditLog); ok {
+		return log.WithCauses(causes)
+	}
+
+	return store
+}
+
+// Diff returns the fields which differ from before to after, before is nil when the user is added.
+func Diff(before, after *User) []FieldChange {
+	if before == nil {
+		before = new(User)
+	}
+
+	var diff []FieldChange
+	for _, f := range []struct{ field, from, to string }{
+		{"username", before.Username, after.Username},
+		{"email", before.Email, after.Email},
+		{"status", before.Status, after.Status},
+	} {
+		if f.from != f.to {
+			diff = append(diff, FieldChange{f.field, f.from, f.to})
+		}
+	}
+
+	return diff
+}
+
+// NewChange returns the change of the user from before to after, caused by c at t.
+func NewChange(before, after *User, c Cause, t time.Time) Change {
+	return Change{
+		UserID:   after.ID,
+		Revision: after.Revision,
+		Diff:     Diff(before, after),
+		Cause:    c,
+		Time:     t.UTC(),
+	}
+}
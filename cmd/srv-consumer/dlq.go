@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message/amqp"
+	"github.com/rafaeljesus/srv-consumer/platform/message/dlq"
+)
+
+// runDLQ lists the dead-lettered messages, optionally replaying or dumping the selected ones.
+func runDLQ(args []string) error {
+	fs := flag.NewFlagSet("dlq", flag.ExitOnError)
+	key := fs.String("routing-key", "", "select messages originally published with the routing key")
+	reason := fs.String("reason", "", "select messages dead-lettered for the reason, e.g. rejected or expired")
+	ids := fs.String("id", "", "select messages by comma separated message ids")
+	limit := fs.Int("limit", 1000, "maximum number of messages fetched from the queue")
+	replay := fs.Bool("replay", false, "publish the selected messages to their original exchange and routing key")
+	dump := fs.String("dump", "", "write the selected messages to the file, one JSON object per line")
+	fs.Parse(args)
+
+	conn, ch, err := amqp.NewConnection(amqpDSN())
+	if err != nil {
+		return fmt.Errorf("failed to init rabbit connection: %v", err)
+	}
+	defer conn.Close()
+
+	q, err := amqp.NewDeadLetters(ch)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead letter queue: %v", err)
+	}
+
+	msgs, err := dlq.Fetch(q, *limit)
+	if err != nil {
+		return fmt.Errorf("failed to fetch dead letters: %v", err)
+	}
+	defer dlq.Release(msgs)
+
+	filter := dlq.Filter{RoutingKey: *key, Reason: *reason}
+	if *ids != "" {
+		filter.MessageIDs = strings.Split(*ids, ",")
+	}
+	selected := dlq.Select(msgs, filter)
+	list(os.Stdout, selected)
+
+	if *dump != "" {
+		f, err := os.Create(*dump)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := dlq.Dump(f, selected); err != nil {
+			return fmt.Errorf("failed to dump messages: %v", err)
+		}
+	}
+
+	if *replay {
+		// published through a channel of its own, so the broker closing it on a failed publishing
+		// doesn't requeue the fetched messages held unacknowledged on ch.
+		pub, err := amqp.OpenPublisher(conn)
+		if err != nil {
+			return fmt.Errorf("failed to init publisher: %v", err)
+		}
+		if err := dlq.Replay(pub, selected); err != nil {
+			return fmt.Errorf("failed to replay messages: %v", err)
+		}
+		fmt.Printf("replayed %d messages\n", len(selected))
+	}
+
+	return nil
+}
+
+func list(w io.Writer, msgs []*dlq.Message) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MESSAGE ID\tEXCHANGE\tROUTING KEY\tREASON\tCOUNT\tTIME")
+	for _, m := range msgs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", m.MessageID, m.Exchange, m.RoutingKey, m.Reason, m.Count, m.Time.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	srv "github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/event"
+	"github.com/rafaeljesus/srv-consumer/platform/message/amqp"
+	"github.com/rafaeljesus/srv-consumer/platform/message/replay"
+)
+
+// runPublish publishes a user event built from flags, or one per line of a JSON lines file.
+func runPublish(args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	exchange := fs.String("exchange", event.Exchange, "exchange to publish to")
+	key := fs.String("key", event.UserCreated, "event routing key: user.created, user.email.changed or user.status.changed")
+	contentType := fs.String("content-type", "application/json", "body content type: application/json, application/msgpack or application/x-protobuf")
+	file := fs.String("file", "", "JSON lines file with one event per line, in the replay format with the user as body")
+	var user srv.User
+	fs.UintVar(&user.ID, "id", 0, "user id")
+	fs.StringVar(&user.Username, "username", "", "user username")
+	fs.StringVar(&user.Email, "email", "", "user email")
+	fs.StringVar(&user.Status, "status", "", "user status")
+	fs.Parse(args)
+
+	conn, ch, err := amqp.NewConnection(amqpDSN())
+	if err != nil {
+		return fmt.Errorf("failed to init rabbit connection: %v", err)
+	}
+	defer conn.Close()
+
+	pub, err := amqp.NewPublisher(ch)
+	if err != nil {
+		return fmt.Errorf("failed to init publisher: %v", err)
+	}
+
+	publish := func(key string, user srv.User) error {
+		p, err := event.New(key, user, *contentType)
+		if err != nil {
+			return err
+		}
+		if err := pub.Publish(*exchange, key, p); err != nil {
+			return err
+		}
+		fmt.Printf("published %s %s\n", key, p.MessageId)
+		return nil
+	}
+
+	if *file == "" {
+		return publish(*key, user)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := replay.NewReader(f)
+	for {
+		rec, line, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var user srv.User
+		if err := json.Unmarshal(rec.Body, &user); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := publish(rec.RoutingKey, user); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
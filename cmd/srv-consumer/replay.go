@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/rafaeljesus/srv-consumer/parking"
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
+	"github.com/rafaeljesus/srv-consumer/platform/message/inmem"
+	"github.com/rafaeljesus/srv-consumer/platform/message/replay"
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
+	"github.com/rafaeljesus/srv-consumer/register"
+	"github.com/rafaeljesus/srv-consumer/storage"
+)
+
+// unrouted is the outcome of records which routing key has no handler.
+const unrouted = "unrouted"
+
+// runReplay feeds the records of a JSON lines file through the handlers and prints the outcome of each.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "", "JSON lines file with one message per line")
+	dryRun := fs.Bool("dry-run", false, "handle the messages against a throwaway in memory store")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("missing -file")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dsn := storeDSN()
+	if *dryRun {
+		dsn = "memory://dry-run"
+	}
+	store, err := storage.Open(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	logger := logging.New(os.Stderr, logging.ParseLevel(os.Getenv("LOG_LEVEL")))
+	broker := inmem.NewBroker()
+	regs := make(map[string]*register.Register)
+	// updates replayed before their user is created are handled once it is, the ones left are unsettled.
+	lot := parking.New(0, stats.NewPrometheus(), logger)
+	for _, e := range routes(store, lot) {
+		reg, err := register.New(e.routingKey, e.exchange, broker, e.handler, stats.NewPrometheus(), logger)
+		if err != nil {
+			return err
+		}
+		regs[e.routingKey] = reg
+	}
+
+	ac := replay.NewAcknowledger()
+	r := replay.NewReader(f)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tMESSAGE ID\tROUTING KEY\tOUTCOME\tSETTLEMENT")
+	outcomes := make(map[string]int)
+	for tag := uint64(1); ; tag++ {
+		rec, line, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		outcome, settlement := unrouted, replay.Unsettled
+		if reg, ok := regs[rec.RoutingKey]; ok {
+			d, err := rec.Delivery(ac, tag)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			outcome = string(reg.Handle(context.Background(), d).Outcome)
+			settlement = ac.Settlement(tag)
+		}
+		outcomes[outcome]++
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", line, rec.MessageID, rec.RoutingKey, outcome, settlement)
+	}
+	tw.Flush()
+
+	names := make([]string, 0, len(outcomes))
+	for name := range outcomes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println()
+	for _, name := range names {
+		fmt.Printf("%s: %d\n", name, outcomes[name])
+	}
+
+	return nil
+}
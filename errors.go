@@ -7,4 +7,6 @@ var (
 	ErrConflict = errors.New("conflict error")
 	// ErrNotFound is the not found error.
 	ErrNotFound = errors.New("not found")
+	// ErrVersionMismatch is returned when saving a user which was saved meanwhile.
+	ErrVersionMismatch = errors.New("version mismatch")
 )
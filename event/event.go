@@ -0,0 +1,151 @@
+package event
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/proto/userpb"
+	"github.com/streadway/amqp"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Exchange is the exchange user events are published to.
+const Exchange = "users"
+
+// Routing keys of the user events.
+const (
+	UserCreated       = "user.created"
+	UserEmailChanged  = "user.email.changed"
+	UserStatusChanged = "user.status.changed"
+	// UserProjectionUpdated is emitted with the user state after every change.
+	UserProjectionUpdated = "user.projection.updated"
+)
+
+var (
+	// ErrUnknownEvent is returned when building an event for an unknown routing key.
+	ErrUnknownEvent = errors.New("unknown event")
+	// ErrInvalidEvent is returned when the user lacks a field the event requires.
+	ErrInvalidEvent = errors.New("invalid event")
+	// ErrUnsupportedContentType is returned when building an event in an unsupported content type.
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+)
+
+// New returns the publishing of the user event with the given routing key,
+// encoded as json, msgpack or protobuf according to contentType.
+func New(key string, user srv.User, contentType string) (amqp.Publishing, error) {
+	if err := validate(key, user); err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	body, err := encode(key, user, contentType)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		ContentType:  contentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    newID(),
+		Timestamp:    time.Now().UTC(),
+		Type:         key,
+		AppId:        "srv-consumer",
+		Body:         body,
+	}, nil
+}
+
+// Projection returns the emitter of the user.projection.updated event to exchange,
+// correlated to the message which caused the change. Unlike the events built with New,
+// the projection carries the user as stored, even when fields such as its username are empty,
+// so emitting it never fails the change.
+func Projection(exchange string) srv.Emitter {
+	return func(user srv.User, change srv.Change) ([]srv.OutboxMessage, error) {
+		body, err := encode(UserProjectionUpdated, user, "application/json")
+		if err != nil {
+			return nil, err
+		}
+
+		return []srv.OutboxMessage{{
+			Exchange:      exchange,
+			RoutingKey:    UserProjectionUpdated,
+			MessageID:     newID(),
+			CorrelationID: change.MessageID,
+			ContentType:   "application/json",
+			Body:          body,
+		}}, nil
+	}
+}
+
+func validate(key string, user srv.User) error {
+	missing := func(field string) error {
+		return fmt.Errorf("%w: %s requires %s", ErrInvalidEvent, key, field)
+	}
+
+	if user.ID == 0 {
+		return missing("id")
+	}
+	if user.Username == "" {
+		return missing("username")
+	}
+
+	switch key {
+	case UserCreated, UserEmailChanged:
+		if user.Email == "" {
+			return missing("email")
+		}
+	case UserStatusChanged:
+		if user.Status == "" {
+			return missing("status")
+		}
+	case UserProjectionUpdated:
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, key)
+	}
+
+	return nil
+}
+
+func encode(key string, user srv.User, contentType string) ([]byte, error) {
+	switch contentType {
+	case "application/json":
+		return json.Marshal(user)
+	case "application/msgpack", "application/x-msgpack":
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(user); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "application/protobuf", "application/x-protobuf":
+		return proto.Marshal(protoEvent(key, user))
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+}
+
+func protoEvent(key string, user srv.User) proto.Message {
+	switch key {
+	case UserEmailChanged:
+		return &userpb.UserEmailChanged{Id: uint64(user.ID), Username: user.Username, Email: user.Email}
+	case UserStatusChanged:
+		return &userpb.UserStatusChanged{Id: uint64(user.ID), Username: user.Username, Status: user.Status}
+	}
+
+	return &userpb.UserCreated{Id: uint64(user.ID), Username: user.Username, Email: user.Email, Status: user.Status}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
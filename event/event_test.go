@@ -0,0 +1,118 @@
+package event
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/message/codec"
+	"github.com/rafaeljesus/srv-consumer/proto/userpb"
+)
+
+func TestEvent(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T)
+	}{
+		{
+			"build json event",
+			testBuildJSONEvent,
+		},
+		{
+			"build protobuf event",
+			testBuildProtobufEvent,
+		},
+		{
+			"fail to build invalid event",
+			testFailToBuildInvalidEvent,
+		},
+		{
+			"emit projection",
+			testEmitProjection,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t)
+		})
+	}
+}
+
+func testBuildJSONEvent(t *testing.T) {
+	user := srv.User{ID: 1, Username: "foo", Email: "foo@bar.com"}
+	p, err := New(UserCreated, user, "")
+	if err != nil {
+		t.Fatalf("expected to build event: %v", err)
+	}
+	if p.ContentType != "application/json" || p.Type != UserCreated || p.MessageId == "" {
+		t.Fatalf("unexpected publishing: %+v", p)
+	}
+
+	var decoded srv.User
+	m := &message.Message{ContentType: p.ContentType, Body: p.Body}
+	if err := codec.Default.Decode(m, &decoded); err != nil {
+		t.Fatalf("expected to decode event: %v", err)
+	}
+	if decoded != user {
+		t.Fatalf("unexpected user: %+v", decoded)
+	}
+
+	// a user created without username is projected as is.
+	if _, err := Projection("projections")(srv.User{ID: 2}, srv.Change{}); err != nil {
+		t.Fatalf("expected to emit projection of user without username: %v", err)
+	}
+}
+
+func testBuildProtobufEvent(t *testing.T) {
+	p, err := New(UserStatusChanged, srv.User{ID: 1, Username: "foo", Status: "active"}, "application/x-protobuf")
+	if err != nil {
+		t.Fatalf("expected to build event: %v", err)
+	}
+
+	e := new(userpb.UserStatusChanged)
+	m := &message.Message{ContentType: p.ContentType, Body: p.Body}
+	if err := codec.Default.Decode(m, e); err != nil {
+		t.Fatalf("expected to decode event: %v", err)
+	}
+	if e.Id != 1 || e.Status != "active" {
+		t.Fatalf("unexpected event: %v", e)
+	}
+}
+
+func testFailToBuildInvalidEvent(t *testing.T) {
+	if _, err := New(UserEmailChanged, srv.User{ID: 1, Username: "foo"}, ""); !errors.Is(err, ErrInvalidEvent) {
+		t.Fatalf("expected to have ErrInvalidEvent: %v", err)
+	}
+	if _, err := New("user.deleted", srv.User{ID: 1, Username: "foo"}, ""); !errors.Is(err, ErrUnknownEvent) {
+		t.Fatalf("expected to have ErrUnknownEvent: %v", err)
+	}
+	if _, err := New(UserCreated, srv.User{ID: 1, Username: "foo", Email: "foo@bar.com"}, "text/xml"); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Fatalf("expected to have ErrUnsupportedContentType: %v", err)
+	}
+}
+
+func testEmitProjection(t *testing.T) {
+	user := srv.User{ID: 1, Username: "foo", Email: "foo@bar.com", Status: "active", Revision: 2}
+	msgs, err := Projection("projections")(user, srv.Change{Cause: srv.Cause{MessageID: "42"}})
+	if err != nil {
+		t.Fatalf("expected to emit projection: %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+
+	m := msgs[0]
+	if m.Exchange != "projections" || m.RoutingKey != UserProjectionUpdated || m.CorrelationID != "42" || m.MessageID == "" {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+
+	var decoded srv.User
+	if err := codec.Default.Decode(&message.Message{ContentType: m.ContentType, Body: m.Body}, &decoded); err != nil {
+		t.Fatalf("expected to decode projection: %v", err)
+	}
+	if decoded != user {
+		t.Fatalf("unexpected user: %+v", decoded)
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+)
+
+// decodeBatch decodes the message bodies into T, the values of the messages which can't be decoded
+// are nil and their errors returned, they are acked as Handle does.
+func (t *Typed[T]) decodeBatch(ctx context.Context, msgs []*message.Message) ([]*T, []error) {
+	values := make([]*T, len(msgs))
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		v := new(T)
+		if err := t.codecs.Decode(m, v); err != nil {
+			logger := logging.FromContext(ctx).With("message_id", m.ID)
+			logger.Warn("failed to decode message body", "error", err)
+			if err := m.Ack(false); err != nil {
+				logger.Error("failed to ack message", "error", err)
+			}
+			errs[i] = err
+			continue
+		}
+		values[i] = v
+	}
+
+	return values, errs
+}
+
+// saveBatch applies the change decoded from each message to its user and saves them with a single
+// SaveMany, saving again one by one the users saved meanwhile. Messages of users not created yet are
+// parked with p, to be handled again by h once created.
+func saveBatch(ctx context.Context, store srv.UserStore, p Parker, h message.Handler, msgs []*message.Message, users []*srv.User, errs []error, apply func(current, user *srv.User), success string) []error {
+	var (
+		idx    []int
+		saved  []*srv.User
+		causes []srv.Cause
+	)
+	for i, user := range users {
+		if user == nil {
+			continue
+		}
+
+		var current *srv.User
+		errs[i] = traceStore(ctx, "Find", func(ctx context.Context) (err error) {
+			current, err = store.Find(ctx, user.ID)
+			return err
+		})
+		if errs[i] != nil {
+			continue
+		}
+		apply(current, user)
+		idx = append(idx, i)
+		saved = append(saved, current)
+		causes = append(causes, cause(msgs[i]))
+	}
+
+	if len(saved) > 0 {
+		var saveErrs []error
+		traceStore(ctx, "SaveMany", func(ctx context.Context) error {
+			saveErrs = srv.CausedEach(store, causes).SaveMany(ctx, saved)
+			return firstError(saveErrs)
+		})
+		for j, i := range idx {
+			errs[i] = saveErrs[j]
+			if errs[i] == srv.ErrVersionMismatch {
+				errs[i] = update(ctx, srv.Caused(store, causes[j]), users[i].ID, func(current *srv.User) {
+					apply(current, users[i])
+				})
+			}
+		}
+	}
+
+	for i, user := range users {
+		if user == nil {
+			continue
+		}
+
+		m := msgs[i]
+		logger := logging.FromContext(ctx).With("message_id", m.ID, "user_id", user.ID, "username", user.Username)
+		switch errs[i] {
+		case nil:
+			logger.Info(success)
+			if err := m.Ack(false); err != nil {
+				errs[i] = err
+			}
+		case srv.ErrNotFound:
+			// the user may not be created yet, as creations are consumed from another binding.
+			logger.Info("user not found, parking message")
+			p.Park(ctx, user.ID, m, h)
+			errs[i] = message.ErrParked
+		case srv.ErrConflict:
+			// requeued, the whole batch would be redelivered forever.
+			logger.Warn("username already taken, rejecting message")
+			if err := m.Reject(false); err != nil {
+				logger.Error("failed to reject message", "error", err)
+			}
+		default:
+			logger.Error("failed to save user to store", "error", errs[i])
+			if err := m.Nack(false, true); err != nil {
+				logger.Error("failed to reject message", "error", err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// firstError returns the first non nil error of errs.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/mock"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/storage/inmem"
+)
+
+func TestHandleBatch(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *inmem.Storage)
+	}{
+		{
+			"add users in batch",
+			testAddUsersInBatch,
+		},
+		{
+			"save users in batch",
+			testSaveUsersInBatch,
+		},
+		{
+			"dead-letter conflicting save in batch",
+			testDeadLetterConflictInBatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t, inmem.New("memory://localhost"))
+		})
+	}
+}
+
+func testAddUsersInBatch(t *testing.T, store *inmem.Storage) {
+	if err := store.Add(context.Background(), &srv.User{Username: "bar"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	acker := new(mock.Acknowledger)
+	acker.AckFunc = func(multiple bool) error { return nil }
+	msgs := []*message.Message{
+		message.New(acker, []byte(`{"id": 7, "username": "foo"}`)),
+		message.New(acker, []byte(`{"id": 8, "username": "bar"}`)),
+		message.New(acker, []byte(`INVALID`)),
+	}
+	msgs[0].ID = "1"
+
+	var released []uint
+	releaser := new(mock.Releaser)
+	releaser.ReleaseFunc = func(ctx context.Context, userID uint) { released = append(released, userID) }
+
+	errs := NewUserCreated(store, releaser).HandleBatch(context.Background(), msgs)
+	if errs[0] != nil || errs[1] != srv.ErrConflict || errs[2] == nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(released) != 1 || released[0] != 7 {
+		t.Fatalf("expected messages parked for the added user to be released: %v", released)
+	}
+
+	changes, err := store.History(2)
+	if err != nil {
+		t.Fatalf("expected to find history: %v", err)
+	}
+	if len(changes) != 1 || changes[0].MessageID != "1" {
+		t.Fatalf("expected message to be recorded as cause: %+v", changes)
+	}
+}
+
+func testSaveUsersInBatch(t *testing.T, store *inmem.Storage) {
+	if err := store.Add(context.Background(), &srv.User{Username: "foo", Email: "foo@mail.com"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	acker := new(mock.Acknowledger)
+	acker.AckFunc = func(multiple bool) error { return nil }
+	rejected := 0
+	acker.RejectFunc = func(requeue bool) error {
+		if !requeue {
+			rejected++
+		}
+		return nil
+	}
+	// both messages change user 1, the second one is saved again once the first one is.
+	msgs := []*message.Message{
+		message.New(acker, []byte(`{"id": 1, "email": "bar@mail.com"}`)),
+		message.New(acker, []byte(`{"id": 1, "email": "baz@mail.com"}`)),
+		message.New(acker, []byte(`{"id": 42, "email": "qux@mail.com"}`)),
+	}
+
+	parker := new(mock.Parker)
+	var h *UserEmailChanged
+	parker.ParkFunc = func(ctx context.Context, userID uint, m *message.Message, handler message.Handler) {
+		if userID != 42 || m != msgs[2] || handler != h {
+			t.Fatalf("unexpected parked message of user %d", userID)
+		}
+	}
+	h = NewUserEmailChanged(store, parker)
+
+	errs := h.HandleBatch(context.Background(), msgs)
+	if errs[0] != nil || errs[1] != nil || errs[2] != message.ErrParked {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if !parker.ParkInvoked || rejected != 0 || acker.NackInvoked {
+		t.Fatal("expected message of unknown user to be parked")
+	}
+
+	user, err := store.Find(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if user.Email != "baz@mail.com" || user.Revision != 3 {
+		t.Fatalf("expected changes to be applied in order: %+v", user)
+	}
+}
+
+func testDeadLetterConflictInBatch(t *testing.T, _ *inmem.Storage) {
+	store := new(mock.UserStore)
+	store.FindFunc = func(ctx context.Context, id uint) (*srv.User, error) {
+		return &srv.User{ID: id, Username: "foo", Revision: 1}, nil
+	}
+	store.SaveManyFunc = func(ctx context.Context, users []*srv.User) []error {
+		return []error{nil, srv.ErrConflict}
+	}
+
+	acker := new(mock.Acknowledger)
+	acker.AckFunc = func(multiple bool) error { return nil }
+	rejected := 0
+	acker.RejectFunc = func(requeue bool) error {
+		if !requeue {
+			rejected++
+		}
+		return nil
+	}
+	msgs := []*message.Message{
+		message.New(acker, []byte(`{"id": 1, "status": "active"}`)),
+		message.New(acker, []byte(`{"id": 2, "status": "active"}`)),
+	}
+
+	errs := NewUserStatusChanged(store, new(mock.Parker)).HandleBatch(context.Background(), msgs)
+	if errs[0] != nil || errs[1] != srv.ErrConflict {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if rejected != 1 || acker.NackInvoked {
+		t.Fatalf("expected conflicting message to be dead-lettered: %d", rejected)
+	}
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+)
+
+type (
+	// Parker holds the messages updating users not created yet.
+	Parker interface {
+		// Park holds m until the user with the given id is created, when h handles it again.
+		Park(ctx context.Context, userID uint, m *message.Message, h message.Handler)
+	}
+
+	// Releaser handles again the messages held for users once created.
+	Releaser interface {
+		// Release handles again the messages held for the user with the given id.
+		Release(ctx context.Context, userID uint)
+	}
+)
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/platform/message/codec"
+	"github.com/rafaeljesus/srv-consumer/proto/userpb"
+	"google.golang.org/protobuf/proto"
+)
+
+type (
+	// protoUser is a codec which decodes a protobuf user event into srv.User.
+	protoUser func(data []byte, user *srv.User) error
+)
+
+// Decode decodes the protobuf event data into v, which must be a *srv.User.
+func (p protoUser) Decode(data []byte, v interface{}) error {
+	user, ok := v.(*srv.User)
+	if !ok {
+		return fmt.Errorf("unexpected value type: %T", v)
+	}
+
+	return p(data, user)
+}
+
+// userCodecs returns the default codecs with protobuf payloads decoded by p,
+// so handlers accept both json and protobuf events.
+func userCodecs(p protoUser) *codec.Registry {
+	r := codec.Default.Clone()
+	r.Register("application/protobuf", p)
+	r.Register("application/x-protobuf", p)
+	return r
+}
+
+func decodeUserCreated(data []byte, user *srv.User) error {
+	e := new(userpb.UserCreated)
+	if err := proto.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	user.ID = uint(e.Id)
+	user.Username = e.Username
+	user.Email = e.Email
+	user.Status = e.Status
+	return nil
+}
+
+func decodeUserEmailChanged(data []byte, user *srv.User) error {
+	e := new(userpb.UserEmailChanged)
+	if err := proto.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	user.ID = uint(e.Id)
+	user.Username = e.Username
+	user.Email = e.Email
+	return nil
+}
+
+func decodeUserStatusChanged(data []byte, user *srv.User) error {
+	e := new(userpb.UserStatusChanged)
+	if err := proto.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	user.ID = uint(e.Id)
+	user.Username = e.Username
+	user.Status = e.Status
+	return nil
+}
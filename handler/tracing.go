@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("github.com/rafaeljesus/srv-consumer/handler")
+
+// traceStore calls the store operation with the context of a child span of ctx.
+func traceStore(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, "UserStore."+op)
+	defer span.End()
+
+	span.SetAttributes(attribute.String("db.operation", op))
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/message/codec"
+)
+
+type (
+	// TypedFunc handles a message which body was already decoded into v.
+	TypedFunc[T any] func(ctx context.Context, m *message.Message, v *T) error
+
+	// Typed is a message handler which decodes the message body into T
+	// according to its content type before calling the underlying TypedFunc.
+	Typed[T any] struct {
+		codecs *codec.Registry
+		fn     TypedFunc[T]
+	}
+)
+
+// NewTyped returns new Typed struct.
+func NewTyped[T any](r *codec.Registry, fn TypedFunc[T]) *Typed[T] {
+	return &Typed[T]{r, fn}
+}
+
+// Handle decodes the message body and calls the typed handler.
+// Messages which can't be decoded are acked, as redelivering them would fail again.
+func (t *Typed[T]) Handle(ctx context.Context, m *message.Message) error {
+	v := new(T)
+	if err := t.codecs.Decode(m, v); err != nil {
+		logger := logging.FromContext(ctx)
+		logger.Warn("failed to decode message body", "error", err)
+		if err := m.Ack(false); err != nil {
+			logger.Error("failed to ack message", "error", err)
+		}
+		return err
+	}
+
+	return t.fn(ctx, m, v)
+}
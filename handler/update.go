@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+)
+
+// maxSaveAttempts bounds how many times a user saved concurrently is reloaded before giving up.
+const maxSaveAttempts = 3
+
+// update loads the user with the given id, applies the change and saves it,
+// reloading and retrying when it was saved meanwhile.
+func update(ctx context.Context, store srv.UserStore, id uint, apply func(user *srv.User)) error {
+	for attempt := 1; ; attempt++ {
+		var user *srv.User
+		err := traceStore(ctx, "Find", func(ctx context.Context) (err error) {
+			user, err = store.Find(ctx, id)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		apply(user)
+		err = traceStore(ctx, "Save", func(ctx context.Context) error { return store.Save(ctx, user) })
+		if err != srv.ErrVersionMismatch || attempt == maxSaveAttempts {
+			return err
+		}
+
+		logging.FromContext(ctx).Debug("user saved concurrently, retrying", "user_id", id, "attempt", attempt)
+	}
+}
+
+// cause returns the cause recorded in the audit log of the changes made handling m.
+func cause(m *message.Message) srv.Cause {
+	return srv.Cause{MessageID: m.ID, RoutingKey: m.RoutingKey}
+}
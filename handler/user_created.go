@@ -2,56 +2,116 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 
 	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
 	"github.com/rafaeljesus/srv-consumer/platform/message"
 )
 
 type (
 	// UserCreated is the message handler.
 	UserCreated struct {
-		store srv.UserStore
+		*Typed[srv.User]
+		store    srv.UserStore
+		releaser Releaser
 	}
 )
 
-// NewUserCreated returns new UserCreated struct.
-func NewUserCreated(s srv.UserStore) *UserCreated {
-	return &UserCreated{s}
+// NewUserCreated returns new UserCreated struct, releasing the messages parked for the created users with r.
+func NewUserCreated(s srv.UserStore, r Releaser) *UserCreated {
+	u := &UserCreated{store: s, releaser: r}
+	u.Typed = NewTyped(userCodecs(decodeUserCreated), u.add)
+	return u
 }
 
-// Handle is the user created message handler.
-func (u *UserCreated) Handle(ctx context.Context, m *message.Message) error {
-	user := new(srv.User)
-	if err := json.Unmarshal(m.Body, user); err != nil {
-		log.Printf("failed to unmarshal message body: %v", err)
-		if err := m.Ack(false); err != nil {
-			log.Printf("failed to ack message: %v", err)
-		}
-		return err
-	}
-
-	err := u.store.Add(user)
+// add handles the decoded user created message. The parked messages are released on the id carried by the
+// event, which they were parked on, rather than the one assigned by the store.
+func (u *UserCreated) add(ctx context.Context, m *message.Message, user *srv.User) error {
+	logger := logging.FromContext(ctx).With("user_id", user.ID, "username", user.Username)
+	id := user.ID
+	err := traceStore(ctx, "Add", func(ctx context.Context) error { return srv.Caused(u.store, cause(m)).Add(ctx, user) })
 	switch err {
 	case nil:
-		log.Print("user successfully added")
+		logger.Info("user successfully added", "email", user.Email)
 		if err := m.Ack(false); err != nil {
 			return fmt.Errorf("failed to ack message: %v", err)
 		}
+		u.releaser.Release(ctx, id)
 		return nil
 	case srv.ErrConflict:
-		log.Print("user already exists")
+		logger.Warn("user already exists")
 		if err := m.Ack(false); err != nil {
-			log.Printf("failed to ack message: %v", err)
+			logger.Error("failed to ack message", "error", err)
 		}
 		return err
 	default:
-		log.Printf("failed to add user to store: %v", err)
+		logger.Error("failed to add user to store", "error", err)
 		if err := m.Nack(false, true); err != nil {
-			log.Printf("failed to reject message: %v", err)
+			logger.Error("failed to reject message", "error", err)
 		}
 		return err
 	}
 }
+
+// HandleBatch handles the user created messages, adding their users with a single AddMany
+// and releasing the parked messages on the ids carried by the events, as add does.
+func (u *UserCreated) HandleBatch(ctx context.Context, msgs []*message.Message) []error {
+	users, errs := u.decodeBatch(ctx, msgs)
+
+	var (
+		idx    []int
+		ids    []uint
+		added  []*srv.User
+		causes []srv.Cause
+	)
+	for i, user := range users {
+		if user != nil {
+			idx = append(idx, i)
+			ids = append(ids, user.ID)
+			added = append(added, user)
+			causes = append(causes, cause(msgs[i]))
+		}
+	}
+	if len(added) == 0 {
+		return errs
+	}
+
+	var addErrs []error
+	traceStore(ctx, "AddMany", func(ctx context.Context) error {
+		addErrs = srv.CausedEach(u.store, causes).AddMany(ctx, added)
+		return firstError(addErrs)
+	})
+
+	for j, i := range idx {
+		m, user := msgs[i], users[i]
+		logger := logging.FromContext(ctx).With("message_id", m.ID, "user_id", user.ID, "username", user.Username)
+		errs[i] = addErrs[j]
+		switch errs[i] {
+		case nil:
+			logger.Info("user successfully added", "email", user.Email)
+			if err := m.Ack(false); err != nil {
+				errs[i] = err
+			}
+		case srv.ErrConflict:
+			logger.Warn("user already exists")
+			if err := m.Ack(false); err != nil {
+				logger.Error("failed to ack message", "error", err)
+			}
+		default:
+			logger.Error("failed to add user to store", "error", errs[i])
+			if err := m.Nack(false, true); err != nil {
+				logger.Error("failed to reject message", "error", err)
+			}
+		}
+	}
+
+	// released once the batch is handled, as its acks are deferred until then.
+	for j := range idx {
+		if addErrs[j] == nil {
+			u.releaser.Release(ctx, ids[j])
+		}
+	}
+
+	return errs
+}
@@ -8,6 +8,9 @@ import (
 	"github.com/rafaeljesus/srv-consumer"
 	"github.com/rafaeljesus/srv-consumer/mock"
 	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/proto/userpb"
+	"github.com/rafaeljesus/srv-consumer/storage/inmem"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
@@ -39,6 +42,14 @@ func TestUserCreated(t *testing.T) {
 			"handle unexpected error",
 			testHandleUnexpectedError,
 		},
+		{
+			"handle protobuf user created",
+			testHandleProtobufUserCreated,
+		},
+		{
+			"record message as cause of user created",
+			testRecordUserCreatedCause,
+		},
 	}
 
 	for _, test := range tests {
@@ -51,13 +62,15 @@ func TestUserCreated(t *testing.T) {
 }
 
 func testHandleUserCreated(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.AddFunc = func(user *srv.User) error {
+	store.AddFunc = func(ctx context.Context, user *srv.User) error {
 		if user.Email != "foo@mail.com" {
 			t.Fatal("unexpected email")
 		}
 		if user.Username != "foo" {
 			t.Fatal("unexpected username")
 		}
+		// the store assigns its own id.
+		user.ID = 1
 		return nil
 	}
 	acker.AckFunc = func(multiple bool) error {
@@ -67,13 +80,21 @@ func testHandleUserCreated(t *testing.T, store *mock.UserStore, acker *mock.Ackn
 		return nil
 	}
 	body := []byte(`{
+		"id": 7,
 		"email": "foo@mail.com",
 		"username": "foo",
 		"status": "new"
 	}`)
 
+	releaser := new(mock.Releaser)
+	releaser.ReleaseFunc = func(ctx context.Context, userID uint) {
+		if userID != 7 {
+			t.Fatalf("expected messages to be released on the event user id: %d", userID)
+		}
+	}
+
 	msg := message.New(acker, body)
-	h := NewUserCreated(store)
+	h := NewUserCreated(store, releaser)
 	err := h.Handle(context.Background(), msg)
 	if err != nil {
 		t.Fatalf("expected to handle user created %v", err)
@@ -84,15 +105,18 @@ func testHandleUserCreated(t *testing.T, store *mock.UserStore, acker *mock.Ackn
 	if !acker.AckInvoked {
 		t.Fatal("expected message.Ack() to be invoked")
 	}
+	if !releaser.ReleaseInvoked {
+		t.Fatal("expected releaser.Release() to be invoked")
+	}
 }
 
 func testFailToUnmarshalBody(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.AddFunc = func(user *srv.User) error { return nil }
+	store.AddFunc = func(ctx context.Context, user *srv.User) error { return nil }
 	acker.AckFunc = func(multiple bool) error { return nil }
 	body := []byte(``)
 
 	msg := message.New(acker, body)
-	h := NewUserCreated(store)
+	h := NewUserCreated(store, nopReleaser())
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err: %v", err)
@@ -106,12 +130,12 @@ func testFailToUnmarshalBody(t *testing.T, store *mock.UserStore, acker *mock.Ac
 }
 
 func testFailAckWhenUnmarshalBodyError(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.AddFunc = func(user *srv.User) error { return nil }
+	store.AddFunc = func(ctx context.Context, user *srv.User) error { return nil }
 	acker.AckFunc = func(multiple bool) error { return errAcker }
 	body := []byte(``)
 
 	msg := message.New(acker, body)
-	h := NewUserCreated(store)
+	h := NewUserCreated(store, nopReleaser())
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err: %v", err)
@@ -125,7 +149,7 @@ func testFailAckWhenUnmarshalBodyError(t *testing.T, store *mock.UserStore, acke
 }
 
 func testHandleConflictError(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.AddFunc = func(user *srv.User) error { return srv.ErrConflict }
+	store.AddFunc = func(ctx context.Context, user *srv.User) error { return srv.ErrConflict }
 	acker.AckFunc = func(multiple bool) error {
 		if multiple {
 			t.Fatal("unexpected multiple")
@@ -139,7 +163,7 @@ func testHandleConflictError(t *testing.T, store *mock.UserStore, acker *mock.Ac
 	}`)
 
 	msg := message.New(acker, body)
-	h := NewUserCreated(store)
+	h := NewUserCreated(store, nopReleaser())
 	err := h.Handle(context.Background(), msg)
 	if err != srv.ErrConflict {
 		t.Fatalf("expected to return err: %v", err)
@@ -153,7 +177,7 @@ func testHandleConflictError(t *testing.T, store *mock.UserStore, acker *mock.Ac
 }
 
 func testHandleUnexpectedError(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.AddFunc = func(user *srv.User) error { return errors.New("unexpected error") }
+	store.AddFunc = func(ctx context.Context, user *srv.User) error { return errors.New("unexpected error") }
 	acker.NackFunc = func(multiple, requeue bool) error {
 		if multiple {
 			t.Fatal("unexpected multiple")
@@ -170,7 +194,7 @@ func testHandleUnexpectedError(t *testing.T, store *mock.UserStore, acker *mock.
 	}`)
 
 	msg := message.New(acker, body)
-	h := NewUserCreated(store)
+	h := NewUserCreated(store, nopReleaser())
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err: %v", err)
@@ -182,3 +206,68 @@ func testHandleUnexpectedError(t *testing.T, store *mock.UserStore, acker *mock.
 		t.Fatal("expected message.Nack() to be invoked")
 	}
 }
+
+func testHandleProtobufUserCreated(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
+	store.AddFunc = func(ctx context.Context, user *srv.User) error {
+		if user.Email != "foo@mail.com" {
+			t.Fatal("unexpected email")
+		}
+		if user.Username != "foo" {
+			t.Fatal("unexpected username")
+		}
+		if user.Status != "new" {
+			t.Fatal("unexpected status")
+		}
+		return nil
+	}
+	acker.AckFunc = func(multiple bool) error { return nil }
+	body, err := proto.Marshal(&userpb.UserCreated{
+		Email:    "foo@mail.com",
+		Username: "foo",
+		Status:   "new",
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal protobuf: %v", err)
+	}
+
+	msg := message.New(acker, body)
+	msg.ContentType = "application/x-protobuf"
+	h := NewUserCreated(store, nopReleaser())
+	if err := h.Handle(context.Background(), msg); err != nil {
+		t.Fatalf("expected to handle user created %v", err)
+	}
+	if !store.AddInvoked {
+		t.Fatal("expected store.Add() to be invoked")
+	}
+	if !acker.AckInvoked {
+		t.Fatal("expected message.Ack() to be invoked")
+	}
+}
+
+func testRecordUserCreatedCause(t *testing.T, _ *mock.UserStore, acker *mock.Acknowledger) {
+	acker.AckFunc = func(multiple bool) error { return nil }
+	store := inmem.New("memory://localhost")
+	msg := message.New(acker, []byte(`{"email": "foo@mail.com", "username": "foo"}`))
+	msg.ID = "42"
+	msg.RoutingKey = "user.created"
+
+	h := NewUserCreated(store, nopReleaser())
+	if err := h.Handle(context.Background(), msg); err != nil {
+		t.Fatalf("expected to handle user created %v", err)
+	}
+
+	changes, err := store.History(1)
+	if err != nil {
+		t.Fatalf("expected to find history: %v", err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+	if changes[0].MessageID != "42" || changes[0].RoutingKey != "user.created" {
+		t.Fatalf("unexpected cause: %+v", changes[0].Cause)
+	}
+}
+
+func nopReleaser() *mock.Releaser {
+	return &mock.Releaser{ReleaseFunc: func(ctx context.Context, userID uint) {}}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/rafaeljesus/srv-consumer"
 	"github.com/rafaeljesus/srv-consumer/mock"
 	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/proto/userpb"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestUserEmailChanged(t *testing.T) {
@@ -26,7 +28,7 @@ func TestUserEmailChanged(t *testing.T) {
 			testShouldFailToUnmarshalBody,
 		},
 		{
-			"when Not found user is supplied, then should fail to save",
+			"when Not found user is supplied, then should park message",
 			testHandleNotFoundError,
 		},
 
@@ -38,11 +40,26 @@ func TestUserEmailChanged(t *testing.T) {
 			"when unable to Ack message, error should be handled properly",
 			testEmailChangeHandlerShouldFailToAck,
 		},
+		{
+			"when protobuf payload is supplied, then should successfully save user",
+			testShouldChangeUserEmailFromProtobuf,
+		},
+		{
+			"when user is saved concurrently, then should reload and retry",
+			testShouldRetryEmailChangeOnVersionMismatch,
+		},
+		{
+			"when username is taken, then should dead-letter message",
+			testEmailChangeShouldDeadLetterConflict,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.scenario, func(t *testing.T) {
 			store := new(mock.UserStore)
+			store.FindFunc = func(ctx context.Context, id uint) (*srv.User, error) {
+				return &srv.User{ID: id, Username: "foo", Email: "foo@mail.com", Status: "inactive", Revision: 1}, nil
+			}
 			acker := new(mock.Acknowledger)
 			test.function(t, store, acker)
 		})
@@ -50,7 +67,7 @@ func TestUserEmailChanged(t *testing.T) {
 }
 
 func testShouldSuccessfullyChangeUserEmail(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error {
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error {
 		if user.Email != "foo@mail.com" {
 			t.Fatal("unexpected email")
 		}
@@ -73,7 +90,7 @@ func testShouldSuccessfullyChangeUserEmail(t *testing.T, store *mock.UserStore,
 	}`)
 
 	msg := message.New(acker, body)
-	handler := NewUserEmailChanged(store)
+	handler := NewUserEmailChanged(store, new(mock.Parker))
 	err := handler.Handle(context.Background(), msg)
 	if err != nil {
 		t.Fatalf("expected error to be nil, but got %v", err)
@@ -87,12 +104,12 @@ func testShouldSuccessfullyChangeUserEmail(t *testing.T, store *mock.UserStore,
 }
 
 func testShouldFailToUnmarshalBody(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return nil }
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error { return nil }
 	acker.AckFunc = func(multiple bool) error { return nil }
 	body := []byte(`INVALID`)
 
 	msg := message.New(acker, body)
-	h := NewUserEmailChanged(store)
+	h := NewUserEmailChanged(store, new(mock.Parker))
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err but got nil")
@@ -106,35 +123,43 @@ func testShouldFailToUnmarshalBody(t *testing.T, store *mock.UserStore, acker *m
 }
 
 func testHandleNotFoundError(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return srv.ErrNotFound }
-	acker.AckFunc = func(multiple bool) error {
-		if multiple {
-			t.Fatal("unexpected multiple")
-		}
-		return nil
-	}
+	store.FindFunc = func(ctx context.Context, id uint) (*srv.User, error) { return nil, srv.ErrNotFound }
 	body := []byte(`{
+		"id": 1,
 		"email": "foo@mail.com",
 		"username": "foo",
 		"status": "active"
 	}`)
 
 	msg := message.New(acker, body)
-	h := NewUserEmailChanged(store)
+	parker := new(mock.Parker)
+	var h *UserEmailChanged
+	parker.ParkFunc = func(ctx context.Context, userID uint, m *message.Message, handler message.Handler) {
+		if userID != 1 {
+			t.Fatalf("unexpected user id: %d", userID)
+		}
+		if m != msg || handler != h {
+			t.Fatal("expected message to be parked with its handler")
+		}
+	}
+	h = NewUserEmailChanged(store, parker)
 	err := h.Handle(context.Background(), msg)
-	if err != srv.ErrNotFound {
+	if err != message.ErrParked {
 		t.Fatalf("expected to return err but got %v", err)
 	}
-	if !store.SaveInvoked {
+	if !parker.ParkInvoked {
+		t.Fatal("expected parker.Park() to be called")
+	}
+	if store.SaveInvoked {
 		t.Fatal("expected store.Save() to not be called")
 	}
-	if !acker.AckInvoked {
-		t.Fatal("expected message.Ack() to be called")
+	if acker.AckInvoked {
+		t.Fatal("expected message to be left unacknowledged")
 	}
 }
 
 func testHandleUnexpectedSaveError(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return errors.New("unexpected error") }
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error { return errors.New("unexpected error") }
 	acker.NackFunc = func(multiple, requeue bool) error {
 		if multiple {
 			t.Fatal("unexpected multiple")
@@ -151,7 +176,7 @@ func testHandleUnexpectedSaveError(t *testing.T, store *mock.UserStore, acker *m
 	}`)
 
 	msg := message.New(acker, body)
-	h := NewUserEmailChanged(store)
+	h := NewUserEmailChanged(store, new(mock.Parker))
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err but got nil")
@@ -165,12 +190,12 @@ func testHandleUnexpectedSaveError(t *testing.T, store *mock.UserStore, acker *m
 }
 
 func testEmailChangeHandlerShouldFailToAck(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return nil }
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error { return nil }
 	acker.AckFunc = func(multiple bool) error { return errAcker }
 	body := []byte(`INVALID`)
 
 	msg := message.New(acker, body)
-	h := NewUserEmailChanged(store)
+	h := NewUserEmailChanged(store, new(mock.Parker))
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err but got nil")
@@ -182,3 +207,95 @@ func testEmailChangeHandlerShouldFailToAck(t *testing.T, store *mock.UserStore,
 		t.Fatal("expected message.Ack() to be called")
 	}
 }
+
+func testShouldChangeUserEmailFromProtobuf(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error {
+		if user.ID != 1 {
+			t.Fatal("unexpected id")
+		}
+		if user.Email != "foo@mail.com" {
+			t.Fatal("unexpected email")
+		}
+		return nil
+	}
+	acker.AckFunc = func(multiple bool) error { return nil }
+	body, err := proto.Marshal(&userpb.UserEmailChanged{
+		Id:       1,
+		Username: "foo",
+		Email:    "foo@mail.com",
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal protobuf: %v", err)
+	}
+
+	msg := message.New(acker, body)
+	msg.ContentType = "application/x-protobuf"
+	handler := NewUserEmailChanged(store, new(mock.Parker))
+	if err := handler.Handle(context.Background(), msg); err != nil {
+		t.Fatalf("expected error to be nil, but got %v", err)
+	}
+	if !store.SaveInvoked {
+		t.Fatal("expected store.save() to be called")
+	}
+	if !acker.AckInvoked {
+		t.Fatal("expected message.ack() to be called")
+	}
+}
+
+func testShouldRetryEmailChangeOnVersionMismatch(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
+	revision := uint64(1)
+	store.FindFunc = func(ctx context.Context, id uint) (*srv.User, error) {
+		return &srv.User{ID: id, Username: "foo", Status: "active", Revision: revision}, nil
+	}
+	saves := 0
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error {
+		saves++
+		if saves == 1 {
+			revision++
+			return srv.ErrVersionMismatch
+		}
+		if user.Revision != 2 {
+			t.Fatalf("unexpected revision: %d", user.Revision)
+		}
+		if user.Email != "foo@mail.com" || user.Status != "active" {
+			t.Fatalf("unexpected user: %+v", user)
+		}
+		return nil
+	}
+	acker.AckFunc = func(multiple bool) error { return nil }
+
+	msg := message.New(acker, []byte(`{"id": 1, "username": "foo", "email": "foo@mail.com"}`))
+	if err := NewUserEmailChanged(store, new(mock.Parker)).Handle(context.Background(), msg); err != nil {
+		t.Fatalf("expected error to be nil, but got %v", err)
+	}
+	if saves != 2 {
+		t.Fatalf("unexpected saves: %d", saves)
+	}
+	if !acker.AckInvoked {
+		t.Fatal("expected message.ack() to be called")
+	}
+}
+
+func testEmailChangeShouldDeadLetterConflict(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error {
+		if user.Username != "foo" {
+			t.Fatalf("expected username to be kept: %s", user.Username)
+		}
+		return srv.ErrConflict
+	}
+	acker.RejectFunc = func(requeue bool) error {
+		if requeue {
+			t.Fatal("unexpected requeue")
+		}
+		return nil
+	}
+	body := []byte(`{"id": 1, "username": "bar", "email": "bar@mail.com"}`)
+
+	err := NewUserEmailChanged(store, new(mock.Parker)).Handle(context.Background(), message.New(acker, body))
+	if err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+	if !acker.RejectInvoked {
+		t.Fatal("expected message.Reject() to be called")
+	}
+}
@@ -2,57 +2,69 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 
 	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
 	"github.com/rafaeljesus/srv-consumer/platform/message"
 )
 
 type (
 	// UserStatusChanged is the message handler.
 	UserStatusChanged struct {
-		store srv.UserStore
+		*Typed[srv.User]
+		store  srv.UserStore
+		parker Parker
 	}
 )
 
-// NewUserStatusChanged returns new UserStatusChanged struct.
-func NewUserStatusChanged(s srv.UserStore) *UserStatusChanged {
-	return &UserStatusChanged{s}
+// NewUserStatusChanged returns new UserStatusChanged struct, parking the messages of users not created yet with p.
+func NewUserStatusChanged(s srv.UserStore, p Parker) *UserStatusChanged {
+	u := &UserStatusChanged{store: s, parker: p}
+	u.Typed = NewTyped(userCodecs(decodeUserStatusChanged), u.save)
+	return u
 }
 
-// Handle is the user status changed message handler.
-func (u *UserStatusChanged) Handle(ctx context.Context, m *message.Message) error {
-	user := new(srv.User)
-	if err := json.Unmarshal(m.Body, user); err != nil {
-		log.Printf("failed to unmarshal message body: %v", err)
-		if err := m.Ack(false); err != nil {
-			log.Printf("failed to ack message: %v", err)
-		}
-		return err
-	}
-
-	err := u.store.Save(user)
+// save handles the decoded user status changed message.
+func (u *UserStatusChanged) save(ctx context.Context, m *message.Message, user *srv.User) error {
+	logger := logging.FromContext(ctx).With("user_id", user.ID, "username", user.Username)
+	err := update(ctx, srv.Caused(u.store, cause(m)), user.ID, func(current *srv.User) { u.apply(current, user) })
 
 	switch err {
 	case nil:
-		log.Print("user status successfully changed")
+		logger.Info("user status successfully changed")
 		if err := m.Ack(false); err != nil {
 			return fmt.Errorf("failed to ack message: %v", err)
 		}
 		return nil
 	case srv.ErrNotFound:
-		log.Print("user not found")
-		if err := m.Ack(false); err != nil {
-			log.Printf("failed to ack message: %v", err)
+		// the user may not be created yet, as creations are consumed from another binding.
+		logger.Info("user not found, parking message")
+		u.parker.Park(ctx, user.ID, m, u)
+		return message.ErrParked
+	case srv.ErrConflict:
+		// requeued, it would conflict again on every redelivery.
+		logger.Warn("username already taken, rejecting message")
+		if err := m.Reject(false); err != nil {
+			logger.Error("failed to reject message", "error", err)
 		}
 		return err
 	default:
-		log.Printf("failed to save user to store: %v", err)
+		logger.Error("failed to save user to store", "error", err)
 		if err := m.Nack(false, true); err != nil {
-			log.Printf("failed to reject message: %v", err)
+			logger.Error("failed to reject message", "error", err)
 		}
 		return err
 	}
 }
+
+// HandleBatch handles the user status changed messages, saving their users with a single SaveMany.
+func (u *UserStatusChanged) HandleBatch(ctx context.Context, msgs []*message.Message) []error {
+	users, errs := u.decodeBatch(ctx, msgs)
+	return saveBatch(ctx, u.store, u.parker, u, msgs, users, errs, u.apply, "user status successfully changed")
+}
+
+// apply changes the status of the current user, other fields such as its username are kept.
+func (u *UserStatusChanged) apply(current, user *srv.User) {
+	current.Status = user.Status
+}
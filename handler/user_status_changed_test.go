@@ -8,6 +8,8 @@ import (
 	"github.com/rafaeljesus/srv-consumer"
 	"github.com/rafaeljesus/srv-consumer/mock"
 	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/proto/userpb"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestUserStatusChanged(t *testing.T) {
@@ -26,7 +28,7 @@ func TestUserStatusChanged(t *testing.T) {
 			testStatusChangeHandlerShouldFailToUnmarshalBody,
 		},
 		{
-			"when Not found user is supplied, then should fail to save",
+			"when Not found user is supplied, then should park message",
 			testStatusChangeHandlerNotFoundError,
 		},
 
@@ -38,11 +40,26 @@ func TestUserStatusChanged(t *testing.T) {
 			"when unable to Ack message, error should be handled properly",
 			testStatusChangeHandlerShouldFailToAck,
 		},
+		{
+			"when protobuf payload is supplied, then should successfully save user",
+			testShouldChangeUserStatusFromProtobuf,
+		},
+		{
+			"when user keeps being saved concurrently, then should requeue",
+			testShouldRequeueStatusChangeWhenRetriesExhausted,
+		},
+		{
+			"when username is taken, then should dead-letter message",
+			testStatusChangeShouldDeadLetterConflict,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.scenario, func(t *testing.T) {
 			store := new(mock.UserStore)
+			store.FindFunc = func(ctx context.Context, id uint) (*srv.User, error) {
+				return &srv.User{ID: id, Username: "foo", Email: "foo@mail.com", Status: "inactive", Revision: 1}, nil
+			}
 			acker := new(mock.Acknowledger)
 			test.function(t, store, acker)
 		})
@@ -50,7 +67,7 @@ func TestUserStatusChanged(t *testing.T) {
 }
 
 func testShouldSuccessfullyChangeUserStatus(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error {
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error {
 		if user.Email != "foo@mail.com" {
 			t.Fatal("unexpected email")
 		}
@@ -73,7 +90,7 @@ func testShouldSuccessfullyChangeUserStatus(t *testing.T, store *mock.UserStore,
 	}`)
 
 	msg := message.New(acker, body)
-	handler := NewUserStatusChanged(store)
+	handler := NewUserStatusChanged(store, new(mock.Parker))
 	err := handler.Handle(context.Background(), msg)
 
 	if err != nil {
@@ -88,12 +105,12 @@ func testShouldSuccessfullyChangeUserStatus(t *testing.T, store *mock.UserStore,
 }
 
 func testStatusChangeHandlerShouldFailToUnmarshalBody(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return nil }
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error { return nil }
 	acker.AckFunc = func(multiple bool) error { return nil }
 	body := []byte(`INVALID`)
 
 	msg := message.New(acker, body)
-	h := NewUserStatusChanged(store)
+	h := NewUserStatusChanged(store, new(mock.Parker))
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err but got nil")
@@ -107,35 +124,43 @@ func testStatusChangeHandlerShouldFailToUnmarshalBody(t *testing.T, store *mock.
 }
 
 func testStatusChangeHandlerNotFoundError(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return srv.ErrNotFound }
-	acker.AckFunc = func(multiple bool) error {
-		if multiple {
-			t.Fatal("unexpected multiple")
-		}
-		return nil
-	}
+	store.FindFunc = func(ctx context.Context, id uint) (*srv.User, error) { return nil, srv.ErrNotFound }
 	body := []byte(`{
+		"id": 1,
 		"email": "foo@mail.com",
 		"username": "foo",
 		"status": "active"
 	}`)
 
 	msg := message.New(acker, body)
-	h := NewUserStatusChanged(store)
+	parker := new(mock.Parker)
+	var h *UserStatusChanged
+	parker.ParkFunc = func(ctx context.Context, userID uint, m *message.Message, handler message.Handler) {
+		if userID != 1 {
+			t.Fatalf("unexpected user id: %d", userID)
+		}
+		if m != msg || handler != h {
+			t.Fatal("expected message to be parked with its handler")
+		}
+	}
+	h = NewUserStatusChanged(store, parker)
 	err := h.Handle(context.Background(), msg)
-	if err != srv.ErrNotFound {
+	if err != message.ErrParked {
 		t.Fatalf("expected to return err but got %v", err)
 	}
-	if !store.SaveInvoked {
+	if !parker.ParkInvoked {
+		t.Fatal("expected parker.Park() to be called")
+	}
+	if store.SaveInvoked {
 		t.Fatal("expected store.Save() to not be called")
 	}
-	if !acker.AckInvoked {
-		t.Fatal("expected message.Ack() to be called")
+	if acker.AckInvoked {
+		t.Fatal("expected message to be left unacknowledged")
 	}
 }
 
 func testStatusChangeHandlerUnexpectedSaveError(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return errors.New("unexpected error") }
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error { return errors.New("unexpected error") }
 	acker.NackFunc = func(multiple, requeue bool) error {
 		if multiple {
 			t.Fatal("unexpected multiple")
@@ -152,7 +177,7 @@ func testStatusChangeHandlerUnexpectedSaveError(t *testing.T, store *mock.UserSt
 	}`)
 
 	msg := message.New(acker, body)
-	h := NewUserStatusChanged(store)
+	h := NewUserStatusChanged(store, new(mock.Parker))
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err but got nil")
@@ -166,12 +191,12 @@ func testStatusChangeHandlerUnexpectedSaveError(t *testing.T, store *mock.UserSt
 }
 
 func testStatusChangeHandlerShouldFailToAck(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
-	store.SaveFunc = func(user *srv.User) error { return nil }
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error { return nil }
 	acker.AckFunc = func(multiple bool) error { return errAcker }
 	body := []byte(`INVALID`)
 
 	msg := message.New(acker, body)
-	h := NewUserStatusChanged(store)
+	h := NewUserStatusChanged(store, new(mock.Parker))
 	err := h.Handle(context.Background(), msg)
 	if err == nil {
 		t.Fatalf("expected to return err but got nil")
@@ -183,3 +208,82 @@ func testStatusChangeHandlerShouldFailToAck(t *testing.T, store *mock.UserStore,
 		t.Fatal("expected message.Ack() to be called")
 	}
 }
+
+func testShouldChangeUserStatusFromProtobuf(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error {
+		if user.ID != 1 {
+			t.Fatal("unexpected id")
+		}
+		if user.Status != "active" {
+			t.Fatal("unexpected status")
+		}
+		return nil
+	}
+	acker.AckFunc = func(multiple bool) error { return nil }
+	body, err := proto.Marshal(&userpb.UserStatusChanged{
+		Id:       1,
+		Username: "foo",
+		Status:   "active",
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal protobuf: %v", err)
+	}
+
+	msg := message.New(acker, body)
+	msg.ContentType = "application/x-protobuf"
+	handler := NewUserStatusChanged(store, new(mock.Parker))
+	if err := handler.Handle(context.Background(), msg); err != nil {
+		t.Fatalf("expected error to be nil, but got %v", err)
+	}
+	if !store.SaveInvoked {
+		t.Fatal("expected store.save() to be called")
+	}
+	if !acker.AckInvoked {
+		t.Fatal("expected message.ack() to be called")
+	}
+}
+
+func testShouldRequeueStatusChangeWhenRetriesExhausted(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error { return srv.ErrVersionMismatch }
+	acker.NackFunc = func(multiple, requeue bool) error {
+		if !requeue {
+			t.Fatal("unexpected requeue")
+		}
+		return nil
+	}
+
+	msg := message.New(acker, []byte(`{"id": 1, "username": "foo", "status": "active"}`))
+	if err := NewUserStatusChanged(store, new(mock.Parker)).Handle(context.Background(), msg); err != srv.ErrVersionMismatch {
+		t.Fatalf("expected to have ErrVersionMismatch: %v", err)
+	}
+	if !store.FindInvoked {
+		t.Fatal("expected store.Find() to be called")
+	}
+	if !acker.NackInvoked {
+		t.Fatal("expected message.Nack() to be called once retries are exhausted")
+	}
+}
+
+func testStatusChangeShouldDeadLetterConflict(t *testing.T, store *mock.UserStore, acker *mock.Acknowledger) {
+	store.SaveFunc = func(ctx context.Context, user *srv.User) error {
+		if user.Username != "foo" {
+			t.Fatalf("expected username to be kept: %s", user.Username)
+		}
+		return srv.ErrConflict
+	}
+	acker.RejectFunc = func(requeue bool) error {
+		if requeue {
+			t.Fatal("unexpected requeue")
+		}
+		return nil
+	}
+	body := []byte(`{"id": 1, "username": "bar", "status": "active"}`)
+
+	err := NewUserStatusChanged(store, new(mock.Parker)).Handle(context.Background(), message.New(acker, body))
+	if err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+	if !acker.RejectInvoked {
+		t.Fatal("expected message.Reject() to be called")
+	}
+}
@@ -6,6 +6,12 @@ type (
 	Consumer struct {
 		ConsumeInvoked bool
 		ConsumeFunc    func(routingKey, exchange string) (<-chan amqp.Delivery, error)
+
+		CancelInvoked bool
+		CancelFunc    func(routingKey string) error
+
+		QosInvoked bool
+		QosFunc    func(prefetch int) error
 	}
 )
 
@@ -13,3 +19,13 @@ func (c *Consumer) Consume(routingKey, exchange string) (<-chan amqp.Delivery, e
 	c.ConsumeInvoked = true
 	return c.ConsumeFunc(routingKey, exchange)
 }
+
+func (c *Consumer) Cancel(routingKey string) error {
+	c.CancelInvoked = true
+	return c.CancelFunc(routingKey)
+}
+
+func (c *Consumer) Qos(prefetch int) error {
+	c.QosInvoked = true
+	return c.QosFunc(prefetch)
+}
@@ -0,0 +1,29 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+)
+
+type (
+	Parker struct {
+		ParkInvoked bool
+		ParkFunc    func(ctx context.Context, userID uint, m *message.Message, h message.Handler)
+	}
+
+	Releaser struct {
+		ReleaseInvoked bool
+		ReleaseFunc    func(ctx context.Context, userID uint)
+	}
+)
+
+func (p *Parker) Park(ctx context.Context, userID uint, m *message.Message, h message.Handler) {
+	p.ParkInvoked = true
+	p.ParkFunc(ctx, userID, m, h)
+}
+
+func (r *Releaser) Release(ctx context.Context, userID uint) {
+	r.ReleaseInvoked = true
+	r.ReleaseFunc(ctx, userID)
+}
@@ -0,0 +1,23 @@
+package mock
+
+import (
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+type (
+	Publisher struct {
+		sync.RWMutex
+		PublishInvoked bool
+		PublishFunc    func(exchange, key string, p amqp.Publishing) error
+	}
+)
+
+func (p *Publisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.PublishInvoked = true
+	return p.PublishFunc(exchange, key, msg)
+}
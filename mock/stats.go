@@ -3,6 +3,8 @@ package mock
 import (
 	"sync"
 	"time"
+
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
 )
 
 type (
@@ -10,11 +12,11 @@ type (
 		sync.RWMutex
 		StartInvoked bool
 		TrackInvoked bool
-		TrackFunc    func(t time.Time, err bool)
+		TrackFunc    func(t time.Time, e stats.Event)
 	}
 )
 
-func (s *Stats) Start() time.Time {
+func (s *Stats) Start(key, handler string) time.Time {
 	s.Lock()
 	defer s.Unlock()
 
@@ -22,10 +24,10 @@ func (s *Stats) Start() time.Time {
 	return time.Now()
 }
 
-func (s *Stats) Track(t time.Time, err bool) {
+func (s *Stats) Track(t time.Time, e stats.Event) {
 	s.Lock()
 	defer s.Unlock()
 
 	s.TrackInvoked = true
-	s.TrackFunc(t, err)
+	s.TrackFunc(t, e)
 }
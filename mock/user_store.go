@@ -1,23 +1,51 @@
 package mock
 
-import srv "github.com/rafaeljesus/srv-consumer"
+import (
+	"context"
+
+	srv "github.com/rafaeljesus/srv-consumer"
+)
 
 type (
 	UserStore struct {
 		AddInvoked bool
-		AddFunc    func(user *srv.User) error
+		AddFunc    func(ctx context.Context, user *srv.User) error
 
 		SaveInvoked bool
-		SaveFunc    func(user *srv.User) error
+		SaveFunc    func(ctx context.Context, user *srv.User) error
+
+		AddManyInvoked bool
+		AddManyFunc    func(ctx context.Context, users []*srv.User) []error
+
+		SaveManyInvoked bool
+		SaveManyFunc    func(ctx context.Context, users []*srv.User) []error
+
+		FindInvoked bool
+		FindFunc    func(ctx context.Context, id uint) (*srv.User, error)
 	}
 )
 
-func (c *UserStore) Add(user *srv.User) error {
+func (c *UserStore) Add(ctx context.Context, user *srv.User) error {
 	c.AddInvoked = true
-	return c.AddFunc(user)
+	return c.AddFunc(ctx, user)
 }
 
-func (c *UserStore) Save(user *srv.User) error {
+func (c *UserStore) Save(ctx context.Context, user *srv.User) error {
 	c.SaveInvoked = true
-	return c.SaveFunc(user)
+	return c.SaveFunc(ctx, user)
+}
+
+func (c *UserStore) AddMany(ctx context.Context, users []*srv.User) []error {
+	c.AddManyInvoked = true
+	return c.AddManyFunc(ctx, users)
+}
+
+func (c *UserStore) SaveMany(ctx context.Context, users []*srv.User) []error {
+	c.SaveManyInvoked = true
+	return c.SaveManyFunc(ctx, users)
+}
+
+func (c *UserStore) Find(ctx context.Context, id uint) (*srv.User, error) {
+	c.FindInvoked = true
+	return c.FindFunc(ctx, id)
 }
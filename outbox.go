@@ -0,0 +1,50 @@
+package srv
+
+import "time"
+
+type (
+	// OutboxMessage is a message to publish, recorded in the transaction of the user mutation it derives from.
+	OutboxMessage struct {
+		ID            uint64    `json:"id"`
+		Exchange      string    `json:"exchange"`
+		RoutingKey    string    `json:"routing_key"`
+		MessageID     string    `json:"message_id,omitempty"`
+		CorrelationID string    `json:"correlation_id,omitempty"`
+		ContentType   string    `json:"content_type,omitempty"`
+		Body          []byte    `json:"body"`
+		CreatedAt     time.Time `json:"created_at"`
+	}
+
+	// Emitter derives the messages to publish from the user mutated by change.
+	Emitter func(user User, change Change) ([]OutboxMessage, error)
+
+	// Outbox is implemented by stores which record messages to publish along with their user mutations,
+	// so they are published even when the process crashes after the mutation.
+	Outbox interface {
+		// WithOutbox returns the store recording the messages emitted for its mutations.
+		WithOutbox(emit Emitter) UserStore
+		// Pending returns up to limit messages not sent yet, oldest first.
+		Pending(limit int) ([]OutboxMessage, error)
+		// MarkSent removes the messages with the given ids from the pending ones.
+		MarkSent(ids ...uint64) error
+	}
+)
+
+// Emitting returns the store recording the messages emitted for its mutations
+// when it has an outbox, otherwise the store itself.
+func Emitting(store UserStore, emit Emitter) UserStore {
+	if outbox, ok := store.(Outbox); ok {
+		return outbox.WithOutbox(emit)
+	}
+
+	return store
+}
+
+// Emit returns the messages emitted for the user mutated by change, none when e is nil.
+func (e Emitter) Emit(user User, change Change) ([]OutboxMessage, error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	return e(user, change)
+}
@@ -0,0 +1,95 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/streadway/amqp"
+)
+
+// batchSize is the number of pending messages read from the outbox at once.
+const batchSize = 100
+
+type (
+	// Publisher publishes messages to an exchange, returning once the broker confirmed them.
+	Publisher interface {
+		Publish(exchange, key string, p amqp.Publishing) error
+	}
+
+	// Relay publishes the messages recorded in an outbox and marks them sent.
+	Relay struct {
+		outbox    srv.Outbox
+		publisher Publisher
+		interval  time.Duration
+		logger    *slog.Logger
+	}
+)
+
+// NewRelay returns a new relay publishing the pending messages of o through p on every interval.
+func NewRelay(o srv.Outbox, p Publisher, interval time.Duration, l *slog.Logger) *Relay {
+	return &Relay{
+		outbox:    o,
+		publisher: p,
+		interval:  interval,
+		logger:    l,
+	}
+}
+
+// Run flushes the outbox on every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := r.Flush()
+			if err != nil {
+				r.logger.Warn("failed to relay outbox messages", "relayed", n, "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Flush publishes the pending messages oldest first, marking each one sent once confirmed,
+// and returns how many were relayed. It stops at the first failure so the order is kept,
+// a message published but not marked sent is published again on the next flush.
+func (r *Relay) Flush() (int, error) {
+	n := 0
+	for {
+		msgs, err := r.outbox.Pending(batchSize)
+		if err != nil {
+			return n, err
+		}
+
+		for _, m := range msgs {
+			if err := r.publisher.Publish(m.Exchange, m.RoutingKey, publishing(m)); err != nil {
+				return n, err
+			}
+			if err := r.outbox.MarkSent(m.ID); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(msgs) < batchSize {
+			return n, nil
+		}
+	}
+}
+
+func publishing(m srv.OutboxMessage) amqp.Publishing {
+	return amqp.Publishing{
+		ContentType:   m.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     m.MessageID,
+		CorrelationId: m.CorrelationID,
+		Timestamp:     m.CreatedAt,
+		Type:          m.RoutingKey,
+		Body:          m.Body,
+	}
+}
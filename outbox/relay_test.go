@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/mock"
+	"github.com/rafaeljesus/srv-consumer/storage/inmem"
+	"github.com/streadway/amqp"
+)
+
+func TestRelay(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *inmem.Storage, *mock.Publisher)
+	}{
+		{
+			"flush pending messages in order",
+			testFlushPendingMessagesInOrder,
+		},
+		{
+			"stop flushing at first failure",
+			testStopFlushingAtFirstFailure,
+		},
+		{
+			"flush on interval",
+			testFlushOnInterval,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			store := inmem.New("memory://localhost")
+			emit := func(user srv.User, change srv.Change) ([]srv.OutboxMessage, error) {
+				return []srv.OutboxMessage{{
+					Exchange:      "projections",
+					RoutingKey:    "user.projection.updated",
+					CorrelationID: change.MessageID,
+					Body:          []byte(user.Username),
+				}}, nil
+			}
+			users := srv.Emitting(store, emit)
+			for _, username := range []string{"foo", "bar"} {
+				if err := users.Add(context.Background(), &srv.User{Username: username}); err != nil {
+					t.Fatalf("expected to add user: %v", err)
+				}
+			}
+			test.function(t, store, new(mock.Publisher))
+		})
+	}
+}
+
+func testFlushPendingMessagesInOrder(t *testing.T, store *inmem.Storage, pub *mock.Publisher) {
+	var published []string
+	pub.PublishFunc = func(exchange, key string, p amqp.Publishing) error {
+		if exchange != "projections" || key != "user.projection.updated" || p.Type != key {
+			t.Fatalf("unexpected publishing: %s %s %+v", exchange, key, p)
+		}
+		published = append(published, string(p.Body))
+		return nil
+	}
+
+	n, err := newRelay(store, pub).Flush()
+	if err != nil {
+		t.Fatalf("expected to flush outbox: %v", err)
+	}
+	if n != 2 || len(published) != 2 || published[0] != "foo" || published[1] != "bar" {
+		t.Fatalf("unexpected published messages: %d %v", n, published)
+	}
+	if pending, _ := store.Pending(10); len(pending) != 0 {
+		t.Fatalf("expected messages to be marked sent: %+v", pending)
+	}
+}
+
+func testStopFlushingAtFirstFailure(t *testing.T, store *inmem.Storage, pub *mock.Publisher) {
+	errPublish := errors.New("publish error")
+	pub.PublishFunc = func(exchange, key string, p amqp.Publishing) error { return errPublish }
+
+	n, err := newRelay(store, pub).Flush()
+	if err != errPublish {
+		t.Fatalf("expected to have publish error: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("unexpected relayed messages: %d", n)
+	}
+	if pending, _ := store.Pending(10); len(pending) != 2 {
+		t.Fatalf("expected messages to be kept pending: %+v", pending)
+	}
+}
+
+func testFlushOnInterval(t *testing.T, store *inmem.Storage, pub *mock.Publisher) {
+	published := make(chan string, 2)
+	pub.PublishFunc = func(exchange, key string, p amqp.Publishing) error {
+		published <- string(p.Body)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go newRelay(store, pub).Run(ctx)
+
+	for _, want := range []string{"foo", "bar"} {
+		select {
+		case got := <-published:
+			if got != want {
+				t.Fatalf("unexpected published message: %s", got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected message to be relayed")
+		}
+	}
+}
+
+func newRelay(store *inmem.Storage, pub *mock.Publisher) *Relay {
+	return NewRelay(store, pub, 10*time.Millisecond, slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+}
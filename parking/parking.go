@@ -0,0 +1,200 @@
+package parking
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer/handler"
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
+)
+
+// minExpireInterval bounds how often parked messages are checked for expiry.
+const minExpireInterval = 10 * time.Millisecond
+
+var (
+	// make sure Lot satisfies handler.Parker interface.
+	_ handler.Parker = (*Lot)(nil)
+	// make sure Lot satisfies handler.Releaser interface.
+	_ handler.Releaser = (*Lot)(nil)
+)
+
+type (
+	// Stats expose methods for collecting metrics.
+	Stats interface {
+		// Start starts the timing metric of a message received for the given routing key and handler.
+		Start(key, handler string) time.Time
+		// Track tracks the handled message which timing started at t.
+		Track(t time.Time, e stats.Event)
+	}
+
+	// Lot holds the messages updating users which are not created yet, leaving them unacknowledged,
+	// until the user is created and they are handled again, or the window expires and they are dead-lettered.
+	// As they are unacknowledged, parked messages are delivered again when the process stops, and count
+	// against the consumer prefetch: a consumer holding as many parked messages as its prefetch receives
+	// no more messages until they are released or expire.
+	Lot struct {
+		window time.Duration
+		stats  Stats
+		logger *slog.Logger
+
+		mu       sync.Mutex
+		parked   map[uint][]*parked
+		released map[uint]time.Time
+	}
+
+	// rehandled marks the context of a parked message handled again, which is parked as usual if it fails again.
+	rehandled struct{}
+
+	// parked is a message held along with the handler which handles it again, and the context it was
+	// parked with, which carries its logger but not its deadline.
+	parked struct {
+		ctx      context.Context
+		msg      *message.Message
+		handler  message.Handler
+		parkedAt time.Time
+	}
+)
+
+// New returns a new Lot holding the parked messages during window.
+func New(window time.Duration, s Stats, l *slog.Logger) *Lot {
+	return &Lot{
+		window:   window,
+		stats:    s,
+		logger:   l,
+		parked:   make(map[uint][]*parked),
+		released: make(map[uint]time.Time),
+	}
+}
+
+// Park holds m until the user with the given id is created, when h handles it again.
+// When the user was released within the window, e.g. created while the handler was parking m,
+// h handles it again right away.
+func (l *Lot) Park(ctx context.Context, userID uint, m *message.Message, h message.Handler) {
+	l.mu.Lock()
+	p := &parked{ctx: context.WithoutCancel(ctx), msg: m, handler: h, parkedAt: time.Now()}
+	if _, ok := l.released[userID]; !ok || ctx.Value(rehandled{}) != nil {
+		l.parked[userID] = append(l.parked[userID], p)
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+
+	l.handle(ctx, userID, p)
+}
+
+// Release handles again the messages parked for the user with the given id, in the order they were parked,
+// each one with the context it was parked with. The user is remembered during the window, so the messages
+// parked meanwhile are handled right away.
+func (l *Lot) Release(ctx context.Context, userID uint) {
+	l.mu.Lock()
+	l.released[userID] = time.Now()
+	ps := l.parked[userID]
+	delete(l.parked, userID)
+	l.mu.Unlock()
+
+	for _, p := range ps {
+		l.handle(p.ctx, userID, p)
+	}
+}
+
+// handle handles a parked message again and tracks its outcome.
+func (l *Lot) handle(ctx context.Context, userID uint, p *parked) {
+	name := handlerName(p.handler)
+	timing := l.stats.Start(p.msg.RoutingKey, name)
+	event := stats.Event{RoutingKey: p.msg.RoutingKey, Handler: name, Size: len(p.msg.Body)}
+
+	err := p.handler.Handle(context.WithValue(ctx, rehandled{}, true), p.msg)
+	switch err {
+	case nil:
+		event.Outcome = stats.Success
+	case message.ErrParked:
+		event.Outcome = stats.Parked
+	default:
+		event.Outcome = stats.Failed
+		logging.FromContext(ctx).Warn("failed to handle parked message", "user_id", userID, "message_id", p.msg.ID, "routing_key", p.msg.RoutingKey, "error", err)
+	}
+	l.stats.Track(timing, event)
+}
+
+// Len returns the number of parked messages.
+func (l *Lot) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, ps := range l.parked {
+		n += len(ps)
+	}
+
+	return n
+}
+
+// Run rejects the messages parked for longer than the window, so they are dead-lettered,
+// until ctx is done.
+func (l *Lot) Run(ctx context.Context) error {
+	interval := l.window / 2
+	if interval < minExpireInterval {
+		interval = minExpireInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.expire(time.Now().Add(-l.window))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// expire rejects the messages parked before deadline and forgets the users released before it.
+func (l *Lot) expire(deadline time.Time) {
+	var expired []*parked
+	l.mu.Lock()
+	for id, at := range l.released {
+		if at.Before(deadline) {
+			delete(l.released, id)
+		}
+	}
+	for id, ps := range l.parked {
+		kept := ps[:0]
+		for _, p := range ps {
+			if p.parkedAt.Before(deadline) {
+				expired = append(expired, p)
+				continue
+			}
+			kept = append(kept, p)
+		}
+		if len(kept) == 0 {
+			delete(l.parked, id)
+			continue
+		}
+		l.parked[id] = kept
+	}
+	l.mu.Unlock()
+
+	for _, p := range expired {
+		name := handlerName(p.handler)
+		timing := l.stats.Start(p.msg.RoutingKey, name)
+		event := stats.Event{RoutingKey: p.msg.RoutingKey, Handler: name, Size: len(p.msg.Body), Outcome: stats.DeadLettered}
+		l.logger.Warn("parked message expired", "message_id", p.msg.ID, "routing_key", p.msg.RoutingKey)
+		if err := p.msg.Reject(false); err != nil {
+			l.logger.Error("failed to reject parked message", "message_id", p.msg.ID, "error", err)
+			event.Outcome = stats.Failed
+		}
+		l.stats.Track(timing, event)
+	}
+}
+
+// handlerName returns the handler type name, e.g. handler.UserStatusChanged.
+func handlerName(h message.Handler) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", h), "*")
+}
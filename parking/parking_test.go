@@ -0,0 +1,277 @@
+package parking
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer/handler"
+	"github.com/rafaeljesus/srv-consumer/mock"
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
+	"github.com/rafaeljesus/srv-consumer/storage/inmem"
+)
+
+var logger = slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+
+func TestLot(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *Lot)
+	}{
+		{
+			"release parked messages in order",
+			testReleaseParkedMessagesInOrder,
+		},
+		{
+			"handle message parked after release",
+			testHandleMessageParkedAfterRelease,
+		},
+		{
+			"expire parked messages",
+			testExpireParkedMessages,
+		},
+		{
+			"reject expired messages on run",
+			testRejectExpiredMessagesOnRun,
+		},
+		{
+			"handle released messages with their own context",
+			testHandleReleasedMessagesWithOwnContext,
+		},
+		{
+			"apply update received before user created",
+			testApplyUpdateReceivedBeforeUserCreated,
+		},
+		{
+			"apply batched update received before user created",
+			testApplyBatchedUpdateReceivedBeforeUserCreated,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t, New(time.Minute, nopStats(), logger))
+		})
+	}
+}
+
+func testReleaseParkedMessagesInOrder(t *testing.T, l *Lot) {
+	var handled []string
+	h := &mock.Handler{HandleFunc: func(ctx context.Context, m *message.Message) error {
+		handled = append(handled, string(m.Body))
+		return nil
+	}}
+	for _, body := range []string{"foo", "bar"} {
+		l.Park(context.Background(), 1, message.New(nil, []byte(body)), h)
+	}
+	l.Park(context.Background(), 2, message.New(nil, []byte("baz")), h)
+
+	l.Release(context.Background(), 1)
+	if len(handled) != 2 || handled[0] != "foo" || handled[1] != "bar" {
+		t.Fatalf("unexpected handled messages: %v", handled)
+	}
+	if l.Len() != 1 {
+		t.Fatalf("expected messages of other users to be kept: %d", l.Len())
+	}
+
+	l.Release(context.Background(), 1)
+	if len(handled) != 2 {
+		t.Fatalf("expected released messages to not be handled again: %v", handled)
+	}
+}
+
+func testHandleMessageParkedAfterRelease(t *testing.T, l *Lot) {
+	var handled []string
+	h := &mock.Handler{HandleFunc: func(ctx context.Context, m *message.Message) error {
+		handled = append(handled, string(m.Body))
+		if len(handled) == 1 {
+			// the user is still not found, the message is parked again.
+			l.Park(ctx, 1, m, nil)
+		}
+		return nil
+	}}
+
+	acker := &mock.Acknowledger{RejectFunc: func(requeue bool) error { return nil }}
+	l.Release(context.Background(), 1)
+	l.Park(context.Background(), 1, message.New(acker, []byte("foo")), h)
+	if len(handled) != 1 || handled[0] != "foo" {
+		t.Fatalf("expected message to be handled right away: %v", handled)
+	}
+	if l.Len() != 1 {
+		t.Fatalf("expected message failing again to be parked: %d", l.Len())
+	}
+
+	l.expire(time.Now().Add(time.Second))
+	if !acker.RejectInvoked {
+		t.Fatal("expected message parked again to expire")
+	}
+	l.Park(context.Background(), 1, message.New(nil, []byte("bar")), h)
+	if len(handled) != 1 || l.Len() != 1 {
+		t.Fatalf("expected message to be parked once the release expired: %v", handled)
+	}
+}
+
+func testExpireParkedMessages(t *testing.T, l *Lot) {
+	acker := &mock.Acknowledger{RejectFunc: func(requeue bool) error {
+		if requeue {
+			t.Fatal("unexpected requeue")
+		}
+		return nil
+	}}
+	l.Park(context.Background(), 1, message.New(acker, nil), new(mock.Handler))
+
+	l.expire(time.Now().Add(-time.Minute))
+	if acker.RejectInvoked || l.Len() != 1 {
+		t.Fatal("expected message parked within window to be kept")
+	}
+
+	l.expire(time.Now().Add(time.Second))
+	if !acker.RejectInvoked {
+		t.Fatal("expected message.Reject() to be invoked")
+	}
+	if l.Len() != 0 {
+		t.Fatalf("expected expired message to be removed: %d", l.Len())
+	}
+}
+
+func testRejectExpiredMessagesOnRun(t *testing.T, _ *Lot) {
+	l := New(20*time.Millisecond, nopStats(), logger)
+	rejected := make(chan bool, 1)
+	acker := &mock.Acknowledger{RejectFunc: func(requeue bool) error {
+		rejected <- requeue
+		return nil
+	}}
+	l.Park(context.Background(), 1, message.New(acker, nil), new(mock.Handler))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Run(ctx)
+
+	select {
+	case <-rejected:
+	case <-time.After(time.Second):
+		t.Fatal("expected expired message to be rejected")
+	}
+}
+
+func testApplyUpdateReceivedBeforeUserCreated(t *testing.T, l *Lot) {
+	store := inmem.New("memory://localhost")
+	created := handler.NewUserCreated(store, l)
+	changed := handler.NewUserStatusChanged(store, l)
+
+	acked := make(map[string]bool)
+	ack := func(name string) *mock.Acknowledger {
+		return &mock.Acknowledger{AckFunc: func(multiple bool) error {
+			acked[name] = true
+			return nil
+		}}
+	}
+
+	update := message.New(ack("update"), []byte(`{"id": 1, "username": "foo", "status": "active"}`))
+	if err := changed.Handle(context.Background(), update); err != message.ErrParked {
+		t.Fatalf("expected message to be parked: %v", err)
+	}
+	if acked["update"] {
+		t.Fatal("expected parked message to be left unacknowledged")
+	}
+
+	create := message.New(ack("create"), []byte(`{"id": 1, "username": "foo", "email": "foo@mail.com", "status": "new"}`))
+	if err := created.Handle(context.Background(), create); err != nil {
+		t.Fatalf("expected to handle user created: %v", err)
+	}
+	if !acked["create"] || !acked["update"] {
+		t.Fatalf("expected both messages to be acked: %v", acked)
+	}
+
+	user, err := store.Find(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if user.Status != "active" || user.Email != "foo@mail.com" {
+		t.Fatalf("expected parked update to be applied: %+v", user)
+	}
+	if l.Len() != 0 {
+		t.Fatalf("unexpected parked messages: %d", l.Len())
+	}
+}
+
+func testApplyBatchedUpdateReceivedBeforeUserCreated(t *testing.T, l *Lot) {
+	store := inmem.New("memory://localhost")
+	created := handler.NewUserCreated(store, l)
+	changed := handler.NewUserEmailChanged(store, l)
+
+	acked := make(map[string]bool)
+	ack := func(name string) *mock.Acknowledger {
+		return &mock.Acknowledger{AckFunc: func(multiple bool) error {
+			acked[name] = true
+			return nil
+		}}
+	}
+
+	updates := []*message.Message{
+		message.New(ack("update"), []byte(`{"id": 1, "email": "bar@mail.com"}`)),
+	}
+	if errs := changed.HandleBatch(context.Background(), updates); errs[0] != message.ErrParked {
+		t.Fatalf("expected message to be parked: %v", errs)
+	}
+	if acked["update"] || l.Len() != 1 {
+		t.Fatal("expected parked message to be left unacknowledged")
+	}
+
+	creates := []*message.Message{
+		message.New(ack("create"), []byte(`{"id": 1, "username": "foo", "email": "foo@mail.com"}`)),
+	}
+	if errs := created.HandleBatch(context.Background(), creates); errs[0] != nil {
+		t.Fatalf("expected to handle user created: %v", errs)
+	}
+	if !acked["create"] || !acked["update"] {
+		t.Fatalf("expected both messages to be acked: %v", acked)
+	}
+
+	user, err := store.Find(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if user.Email != "bar@mail.com" {
+		t.Fatalf("expected parked update to be applied: %+v", user)
+	}
+}
+
+func testHandleReleasedMessagesWithOwnContext(t *testing.T, _ *Lot) {
+	var events []stats.Event
+	s := &mock.Stats{TrackFunc: func(tm time.Time, e stats.Event) { events = append(events, e) }}
+	l := New(time.Minute, s, logger)
+
+	parkLogger := slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	h := &mock.Handler{HandleFunc: func(ctx context.Context, m *message.Message) error {
+		if logging.FromContext(ctx) != parkLogger {
+			t.Fatal("expected message to be handled with the logger it was parked with")
+		}
+		if ctx.Err() != nil {
+			t.Fatal("expected message to outlive the context it was parked with")
+		}
+		return nil
+	}}
+
+	parkCtx, cancel := context.WithCancel(logging.WithContext(context.Background(), parkLogger))
+	m := message.New(nil, []byte("foo"))
+	m.RoutingKey = "user.status.changed"
+	l.Park(parkCtx, 1, m, h)
+	cancel()
+
+	l.Release(logging.WithContext(context.Background(), logger), 1)
+	if len(events) != 1 {
+		t.Fatalf("expected released message to be tracked: %v", events)
+	}
+	if e := events[0]; e.RoutingKey != "user.status.changed" || e.Handler != "mock.Handler" || e.Outcome != stats.Success {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
+
+func nopStats() *mock.Stats {
+	return &mock.Stats{TrackFunc: func(tm time.Time, e stats.Event) {}}
+}
@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timeout bounds the time spent running the checks of a probe.
+const timeout = 5 * time.Second
+
+type (
+	// Checker reports whether a component is healthy.
+	Checker interface {
+		// Check returns an error when the component is unhealthy.
+		Check(ctx context.Context) error
+	}
+
+	// CheckerFunc is an adapter to allow the use of ordinary functions as Checker.
+	CheckerFunc func(ctx context.Context) error
+
+	// Health holds the readiness and liveness checks served by the probe endpoints.
+	Health struct {
+		mu        sync.RWMutex
+		readiness map[string]Checker
+		liveness  map[string]Checker
+	}
+
+	// report is the probe response body.
+	report struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks,omitempty"`
+	}
+)
+
+// Check calls f(ctx).
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Recent returns a Checker which fails when progress didn't advance within window.
+func Recent(progress func() time.Time, window time.Duration) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if last := progress(); time.Since(last) > window {
+			return fmt.Errorf("no progress since %s", last.Format(time.RFC3339))
+		}
+		return nil
+	})
+}
+
+// New returns a new Health with no checks.
+func New() *Health {
+	return &Health{
+		readiness: make(map[string]Checker),
+		liveness:  make(map[string]Checker),
+	}
+}
+
+// Ready adds a readiness check served by /readyz.
+func (h *Health) Ready(name string, c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.readiness[name] = c
+}
+
+// Live adds a liveness check served by /livez.
+func (h *Health) Live(name string, c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.liveness[name] = c
+}
+
+// Handler returns the http handler serving the /healthz, /readyz and /livez endpoints.
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		write(w, report{Status: "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		write(w, h.run(r.Context(), h.readiness))
+	})
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		write(w, h.run(r.Context(), h.liveness))
+	})
+
+	return mux
+}
+
+func (h *Health) run(ctx context.Context, checks map[string]Checker) report {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	h.mu.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cs := make([]Checker, len(names))
+	for i, name := range names {
+		cs[i] = checks[name]
+	}
+	h.mu.RUnlock()
+
+	rep := report{Status: "ok", Checks: make(map[string]string, len(names))}
+	for i, c := range cs {
+		if err := c.Check(ctx); err != nil {
+			rep.Status = "unavailable"
+			rep.Checks[names[i]] = err.Error()
+			continue
+		}
+		rep.Checks[names[i]] = "ok"
+	}
+
+	return rep
+}
+
+func write(w http.ResponseWriter, rep report) {
+	w.Header().Set("Content-Type", "application/json")
+	if rep.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *Health)
+	}{
+		{
+			"serve healthz",
+			testServeHealthz,
+		},
+		{
+			"serve ready",
+			testServeReady,
+		},
+		{
+			"serve not ready",
+			testServeNotReady,
+		},
+		{
+			"serve not live when no recent progress",
+			testServeNotLive,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t, New())
+		})
+	}
+}
+
+func testServeHealthz(t *testing.T, h *Health) {
+	h.Ready("amqp", CheckerFunc(func(ctx context.Context) error { return errors.New("closed") }))
+
+	rec := serve(h, "/healthz")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+}
+
+func testServeReady(t *testing.T, h *Health) {
+	h.Ready("amqp", CheckerFunc(func(ctx context.Context) error { return nil }))
+
+	rec := serve(h, "/readyz")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"amqp":"ok"`) {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+}
+
+func testServeNotReady(t *testing.T, h *Health) {
+	h.Ready("amqp", CheckerFunc(func(ctx context.Context) error { return nil }))
+	h.Ready("store", CheckerFunc(func(ctx context.Context) error { return errors.New("unreachable") }))
+
+	rec := serve(h, "/readyz")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"store":"unreachable"`) {
+		t.Fatalf("unexpected body: %s", rec.Body)
+	}
+}
+
+func testServeNotLive(t *testing.T, h *Health) {
+	last := time.Now().Add(-time.Hour)
+	h.Live("register", Recent(func() time.Time { return last }, time.Minute))
+
+	rec := serve(h, "/livez")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+
+	last = time.Now()
+	rec = serve(h, "/livez")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", rec.Code)
+	}
+}
+
+func serve(h *Health, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Redacted replaces the value of PII fields.
+const Redacted = "[REDACTED]"
+
+type ctxKey struct{}
+
+var (
+	// piiKeys are the attribute keys which values are redacted.
+	piiKeys = map[string]bool{
+		"email":    true,
+		"password": true,
+		"phone":    true,
+	}
+)
+
+// New returns a JSON logger writing to w entries at or above level, with PII fields redacted.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}))
+}
+
+// ParseLevel parses a level name such as debug, info, warn or error, defaulting to info.
+func ParseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+
+	return l
+}
+
+// WithContext returns a copy of ctx carrying the logger.
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+
+	return slog.Default()
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if piiKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+
+	return a
+}
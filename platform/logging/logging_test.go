@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *bytes.Buffer, *slog.Logger)
+	}{
+		{
+			"redact pii fields",
+			testRedactPII,
+		},
+		{
+			"carry logger in context",
+			testCarryLoggerInContext,
+		},
+		{
+			"filter entries below level",
+			testFilterBelowLevel,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			test.function(t, buf, New(buf, ParseLevel("info")))
+		})
+	}
+}
+
+func testRedactPII(t *testing.T, buf *bytes.Buffer, l *slog.Logger) {
+	l.Info("user successfully added", "username", "foo", "email", "foo@mail.com")
+
+	entry := decode(t, buf)
+	if entry["email"] != Redacted {
+		t.Fatalf("unexpected email: %v", entry["email"])
+	}
+	if entry["username"] != "foo" {
+		t.Fatalf("unexpected username: %v", entry["username"])
+	}
+}
+
+func testCarryLoggerInContext(t *testing.T, buf *bytes.Buffer, l *slog.Logger) {
+	ctx := WithContext(context.Background(), l.With("message_id", "1"))
+	FromContext(ctx).Info("user not found")
+
+	entry := decode(t, buf)
+	if entry["message_id"] != "1" {
+		t.Fatalf("unexpected message_id: %v", entry["message_id"])
+	}
+	if entry["msg"] != "user not found" {
+		t.Fatalf("unexpected msg: %v", entry["msg"])
+	}
+	if FromContext(context.Background()) != slog.Default() {
+		t.Fatal("expected default logger")
+	}
+}
+
+func testFilterBelowLevel(t *testing.T, buf *bytes.Buffer, l *slog.Logger) {
+	l.Debug("debugging")
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected entry: %s", buf)
+	}
+	if ParseLevel("unknown") != slog.LevelInfo {
+		t.Fatal("expected to default to info level")
+	}
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode entry %s: %v", buf, err)
+	}
+
+	return entry
+}
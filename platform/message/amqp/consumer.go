@@ -27,13 +27,23 @@ func NewConsumer(ch *amqp.Channel) *Consumer {
 	return &Consumer{ch}
 }
 
+// Queue returns the name of the queue bound to the routing key.
+func (c *Consumer) Queue(key string) string {
+	return queue + "." + key
+}
+
 // Consume creates a amqp consumer
 func (c *Consumer) Consume(key, exchange string) (<-chan amqp.Delivery, error) {
 	if err := c.ch.ExchangeDeclare(exchange, kind, true, false, false, false, nil); err != nil {
 		return nil, err
 	}
 
-	q, err := c.ch.QueueDeclare(queue, true, false, false, false, nil)
+	if err := declareDeadLetter(c.ch); err != nil {
+		return nil, err
+	}
+
+	args := amqp.Table{"x-dead-letter-exchange": DeadLetterExchange}
+	q, err := c.ch.QueueDeclare(c.Queue(key), true, false, false, false, args)
 	if err != nil {
 		return nil, err
 	}
@@ -42,5 +52,16 @@ func (c *Consumer) Consume(key, exchange string) (<-chan amqp.Delivery, error) {
 		return nil, err
 	}
 
-	return c.ch.Consume(q.Name, "", false, false, false, false, nil)
+	return c.ch.Consume(q.Name, c.Queue(key), false, false, false, false, nil)
+}
+
+// Cancel stops the consumer of the routing key.
+func (c *Consumer) Cancel(key string) error {
+	return c.ch.Cancel(c.Queue(key), false)
+}
+
+// Qos sets the prefetch count of the consumers created afterwards. It applies to every consumer
+// of the channel, so a consumer which prefetch is tuned on its own needs a channel of its own.
+func (c *Consumer) Qos(prefetch int) error {
+	return c.ch.Qos(prefetch, 0, false)
 }
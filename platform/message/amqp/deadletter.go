@@ -0,0 +1,45 @@
+package amqp
+
+import "github.com/streadway/amqp"
+
+const (
+	// DeadLetterExchange receives the messages rejected by the consumers without requeue.
+	DeadLetterExchange = queue + ".dlx"
+	// DeadLetterQueue holds the dead-lettered messages of every routing key.
+	DeadLetterQueue = queue + ".dlq"
+)
+
+type (
+	// DeadLetters reads the dead letter queue.
+	DeadLetters struct {
+		ch *amqp.Channel
+	}
+)
+
+// NewDeadLetters returns a new DeadLetters, declaring the dead letter exchange and queue.
+func NewDeadLetters(ch *amqp.Channel) (*DeadLetters, error) {
+	if err := declareDeadLetter(ch); err != nil {
+		return nil, err
+	}
+
+	return &DeadLetters{ch}, nil
+}
+
+// Get returns the next dead-lettered delivery, ok is false when the queue is empty.
+// The delivery must be acknowledged to be removed from the queue.
+func (d *DeadLetters) Get() (amqp.Delivery, bool, error) {
+	return d.ch.Get(DeadLetterQueue, false)
+}
+
+// declareDeadLetter declares the dead letter exchange and a queue bound to every routing key.
+func declareDeadLetter(ch *amqp.Channel) error {
+	if err := ch.ExchangeDeclare(DeadLetterExchange, kind, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	if _, err := ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	return ch.QueueBind(DeadLetterQueue, "#", DeadLetterExchange, false, nil)
+}
@@ -0,0 +1,56 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrConnectionClosed is returned when the amqp connection was closed.
+	ErrConnectionClosed = errors.New("amqp connection closed")
+	// ErrChannelClosed is returned when the amqp channel was closed.
+	ErrChannelClosed = errors.New("amqp channel closed")
+)
+
+type (
+	// Monitor tracks whether the amqp connection and channels are open.
+	Monitor struct {
+		mu  sync.RWMutex
+		err error
+	}
+)
+
+// NewMonitor returns a monitor watching conn close notifications.
+func NewMonitor(conn *amqp.Connection) *Monitor {
+	m := new(Monitor)
+	go m.watch(conn.NotifyClose(make(chan *amqp.Error, 1)), ErrConnectionClosed)
+
+	return m
+}
+
+// Watch watches ch close notifications too.
+func (m *Monitor) Watch(ch *amqp.Channel) {
+	go m.watch(ch.NotifyClose(make(chan *amqp.Error, 1)), ErrChannelClosed)
+}
+
+// Check returns an error when the connection or one of the channels was closed.
+func (m *Monitor) Check(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.err
+}
+
+func (m *Monitor) watch(c <-chan *amqp.Error, err error) {
+	<-c
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err == nil {
+		m.err = err
+	}
+}
@@ -0,0 +1,218 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// confirmTimeout bounds the wait for the broker to confirm a publishing.
+	confirmTimeout = 5 * time.Second
+	// notifyBuffer is how many confirms and returns are queued, so the late ones
+	// of timed out publishings don't block the channel until the next Publish.
+	notifyBuffer = 64
+)
+
+var (
+	// ErrUnroutable is returned when a mandatory publishing is returned as no queue is bound to its routing key.
+	ErrUnroutable = errors.New("message unroutable")
+	// ErrNacked is returned when the broker negatively confirms a publishing.
+	ErrNacked = errors.New("message nacked by the broker")
+	// ErrConfirmTimeout is returned when the broker doesn't confirm a publishing in time.
+	ErrConfirmTimeout = errors.New("timed out waiting for publisher confirm")
+)
+
+type (
+	// Publisher publishes messages to an exchange, waiting for the broker to confirm each one.
+	Publisher struct {
+		ch        *amqp.Channel
+		open      func() (*amqp.Channel, error)
+		mu        sync.Mutex
+		mandatory bool
+		confirms  chan amqp.Confirmation
+		returns   chan amqp.Return
+		closes    chan *amqp.Error
+		// published is the number of publishings, the delivery tag of the last one.
+		published uint64
+	}
+)
+
+// NewPublisher returns a new publisher, putting the channel in confirm mode.
+// The channel must not be used by anything else.
+func NewPublisher(ch *amqp.Channel) (*Publisher, error) {
+	p := &Publisher{mandatory: true}
+	if err := p.use(ch); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// OpenPublisher returns a new publisher on a channel of its own opened from conn. Once the broker
+// closes the channel, e.g. on a publishing to an exchange which doesn't exist, the next Publish opens a new one.
+func OpenPublisher(conn *amqp.Connection) (*Publisher, error) {
+	p := &Publisher{open: conn.Channel, mandatory: true}
+	if err := p.reopen(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// SetMandatory sets whether publishings are mandatory, the default. Publishing a mandatory message
+// no queue is bound to fails with ErrUnroutable, while the broker drops it silently otherwise.
+func (p *Publisher) SetMandatory(mandatory bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.mandatory = mandatory
+}
+
+// DeclareExchange declares the durable topic exchange with the given name, unless it exists.
+func (p *Publisher) DeclareExchange(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ready(); err != nil {
+		return err
+	}
+	if err := p.ch.ExchangeDeclare(name, kind, true, false, false, false, nil); err != nil {
+		p.lost()
+		return err
+	}
+
+	return nil
+}
+
+// Publish publishes msg to the exchange with the given routing key, persistent unless told
+// otherwise, and waits until the broker confirms it.
+func (p *Publisher) Publish(exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ready(); err != nil {
+		return err
+	}
+	if msg.DeliveryMode == 0 {
+		msg.DeliveryMode = amqp.Persistent
+	}
+	if err := p.ch.Publish(exchange, key, p.mandatory, false, msg); err != nil {
+		if err == amqp.ErrClosed {
+			return p.lost()
+		}
+		return err
+	}
+	// the channel is the publisher's own, so its confirm tags count the publishings from 1.
+	p.published++
+	seq := p.published
+
+	timer := time.NewTimer(confirmTimeout)
+	defer timer.Stop()
+
+	// the broker returns an unroutable publishing before confirming it, and confirms in order.
+	var returned *amqp.Return
+	for {
+		select {
+		case r, ok := <-p.returns:
+			if !ok {
+				return p.lost()
+			}
+			returned = &r
+		case c, ok := <-p.confirms:
+			if !ok {
+				return p.lost()
+			}
+			if c.DeliveryTag < seq {
+				// the late confirm of a timed out publishing, the returns queued so far are its own.
+				returned = nil
+				p.drainReturns()
+				continue
+			}
+			select {
+			case r, ok := <-p.returns:
+				if ok {
+					returned = &r
+				}
+			default:
+			}
+			if returned != nil {
+				return fmt.Errorf("%w: %s", ErrUnroutable, returned.ReplyText)
+			}
+			if !c.Ack {
+				return ErrNacked
+			}
+			return nil
+		case <-timer.C:
+			return ErrConfirmTimeout
+		}
+	}
+}
+
+// use puts ch in confirm mode and publishes through it from now on.
+func (p *Publisher) use(ch *amqp.Channel) error {
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+
+	p.ch = ch
+	p.published = 0
+	p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, notifyBuffer))
+	p.returns = ch.NotifyReturn(make(chan amqp.Return, notifyBuffer))
+	p.closes = ch.NotifyClose(make(chan *amqp.Error, 1))
+	return nil
+}
+
+// reopen publishes through a new channel.
+func (p *Publisher) reopen() error {
+	ch, err := p.open()
+	if err != nil {
+		return err
+	}
+	if err := p.use(ch); err != nil {
+		ch.Close()
+		return err
+	}
+
+	return nil
+}
+
+// ready opens a new channel when the previous one was lost.
+func (p *Publisher) ready() error {
+	if p.ch != nil {
+		return nil
+	}
+
+	return p.reopen()
+}
+
+// lost returns why the broker closed the channel, which is dropped when a new one can be opened.
+func (p *Publisher) lost() error {
+	var err error = amqp.ErrClosed
+	select {
+	case e, ok := <-p.closes:
+		if ok && e != nil {
+			err = e
+		}
+	default:
+	}
+	if p.open != nil {
+		p.ch = nil
+	}
+
+	return err
+}
+
+// drainReturns discards the queued returns.
+func (p *Publisher) drainReturns() {
+	for {
+		select {
+		case <-p.returns:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package message
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+)
+
+const (
+	// CloudEventsContentType is the content type of structured mode CloudEvents.
+	CloudEventsContentType = "application/cloudevents+json"
+
+	// cloudEventsPrefix is the header prefix of binary mode CloudEvents attributes.
+	cloudEventsPrefix = "cloudEvents:"
+	// cloudEventsJMSPrefix is the alternative prefix for brokers that do not allow ':' in header names.
+	cloudEventsJMSPrefix = "cloudEvents_"
+)
+
+var (
+	// ErrInvalidCloudEvent is returned when a message looks like a CloudEvent but is malformed.
+	ErrInvalidCloudEvent = errors.New("invalid cloud event")
+)
+
+type (
+	// cloudEvent is the structured mode representation of a CloudEvent.
+	cloudEvent struct {
+		SpecVersion     string          `json:"specversion"`
+		ID              string          `json:"id"`
+		Source          string          `json:"source"`
+		Type            string          `json:"type"`
+		Subject         string          `json:"subject"`
+		Time            string          `json:"time"`
+		DataContentType string          `json:"datacontenttype"`
+		Data            json.RawMessage `json:"data"`
+		DataBase64      string          `json:"data_base64"`
+	}
+)
+
+// DecodeCloudEvent fills in the message metadata from CloudEvents attributes.
+// Binary mode attributes are read from the cloudEvents: prefixed headers, structured
+// mode attributes from an application/cloudevents+json body, in which case the body
+// is replaced by the event data. Messages which are not CloudEvents are left untouched.
+func DecodeCloudEvent(m *Message) error {
+	mediaType, _, _ := mime.ParseMediaType(m.ContentType)
+	if mediaType == CloudEventsContentType {
+		return decodeStructured(m)
+	}
+
+	return decodeBinary(m)
+}
+
+func decodeStructured(m *Message) error {
+	ce := new(cloudEvent)
+	if err := json.Unmarshal(m.Body, ce); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCloudEvent, err)
+	}
+	if err := requireAttributes(map[string]interface{}{
+		"specversion": ce.SpecVersion,
+		"id":          ce.ID,
+		"source":      ce.Source,
+		"type":        ce.Type,
+	}); err != nil {
+		return err
+	}
+
+	t, err := parseTime(ce.Time)
+	if err != nil {
+		return err
+	}
+
+	body := []byte(ce.Data)
+	contentType := ce.DataContentType
+	if ce.DataBase64 != "" {
+		if body, err = base64.StdEncoding.DecodeString(ce.DataBase64); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCloudEvent, err)
+		}
+	} else if contentType == "" {
+		contentType = "application/json"
+	}
+
+	m.ID = ce.ID
+	m.Source = ce.Source
+	m.Type = ce.Type
+	m.Subject = ce.Subject
+	m.Time = t
+	m.ContentType = contentType
+	m.Body = body
+
+	return nil
+}
+
+func decodeBinary(m *Message) error {
+	attrs := make(map[string]interface{})
+	for k, v := range m.Headers {
+		switch {
+		case strings.HasPrefix(k, cloudEventsPrefix):
+			attrs[strings.TrimPrefix(k, cloudEventsPrefix)] = v
+		case strings.HasPrefix(k, cloudEventsJMSPrefix):
+			attrs[strings.TrimPrefix(k, cloudEventsJMSPrefix)] = v
+		}
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	if err := requireAttributes(attrs); err != nil {
+		return err
+	}
+
+	var t time.Time
+	switch v := attrs["time"].(type) {
+	case time.Time:
+		t = v
+	default:
+		var err error
+		if t, err = parseTime(attrString(v)); err != nil {
+			return err
+		}
+	}
+
+	m.ID = attrString(attrs["id"])
+	m.Source = attrString(attrs["source"])
+	m.Type = attrString(attrs["type"])
+	m.Subject = attrString(attrs["subject"])
+	m.Time = t
+	if ct := attrString(attrs["datacontenttype"]); ct != "" && m.ContentType == "" {
+		m.ContentType = ct
+	}
+
+	return nil
+}
+
+// requireAttributes returns which of the required attributes is missing from attrs.
+func requireAttributes(attrs map[string]interface{}) error {
+	for _, name := range []string{"specversion", "id", "source", "type"} {
+		if attrString(attrs[name]) == "" {
+			return fmt.Errorf("%w: missing %s attribute", ErrInvalidCloudEvent, name)
+		}
+	}
+
+	return nil
+}
+
+func attrString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return ""
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrInvalidCloudEvent, err)
+	}
+
+	return t, nil
+}
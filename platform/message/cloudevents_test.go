@@ -0,0 +1,187 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestCloudEvents(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T)
+	}{
+		{
+			"decode binary mode cloud event",
+			testDecodeBinaryCloudEvent,
+		},
+		{
+			"decode structured mode cloud event",
+			testDecodeStructuredCloudEvent,
+		},
+		{
+			"decode structured mode cloud event with base64 data",
+			testDecodeStructuredBase64CloudEvent,
+		},
+		{
+			"fail to decode malformed structured cloud event",
+			testFailToDecodeMalformedCloudEvent,
+		},
+		{
+			"fail to decode binary cloud event missing attributes",
+			testFailToDecodeBinaryMissingAttributes,
+		},
+		{
+			"keep plain amqp messages untouched",
+			testKeepPlainMessage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, test.function)
+	}
+}
+
+func testDecodeBinaryCloudEvent(t *testing.T) {
+	d := amqp.Delivery{
+		ContentType: "application/json",
+		Headers: amqp.Table{
+			"cloudEvents:specversion": "1.0",
+			"cloudEvents:id":          "1",
+			"cloudEvents:source":      "/users",
+			"cloudEvents:type":        "user.created",
+			"cloudEvents:subject":     "foo",
+			"cloudEvents:time":        "2018-04-05T17:31:00Z",
+		},
+		Body: []byte(`{"username":"foo"}`),
+	}
+
+	m, err := FromDelivery(d)
+	if err != nil {
+		t.Fatalf("expected to decode cloud event: %v", err)
+	}
+	if m.ID != "1" {
+		t.Fatalf("unexpected id: %s", m.ID)
+	}
+	if m.Source != "/users" {
+		t.Fatalf("unexpected source: %s", m.Source)
+	}
+	if m.Type != "user.created" {
+		t.Fatalf("unexpected type: %s", m.Type)
+	}
+	if m.Subject != "foo" {
+		t.Fatalf("unexpected subject: %s", m.Subject)
+	}
+	if !m.Time.Equal(time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected time: %v", m.Time)
+	}
+	if string(m.Body) != `{"username":"foo"}` {
+		t.Fatalf("unexpected body: %s", m.Body)
+	}
+}
+
+func testDecodeStructuredCloudEvent(t *testing.T) {
+	d := amqp.Delivery{
+		ContentType: "application/cloudevents+json; charset=utf-8",
+		Body: []byte(`{
+			"specversion": "1.0",
+			"id": "1",
+			"source": "/users",
+			"type": "user.created",
+			"time": "2018-04-05T17:31:00Z",
+			"data": {"username": "foo"}
+		}`),
+	}
+
+	m, err := FromDelivery(d)
+	if err != nil {
+		t.Fatalf("expected to decode cloud event: %v", err)
+	}
+	if m.ID != "1" {
+		t.Fatalf("unexpected id: %s", m.ID)
+	}
+	if m.Type != "user.created" {
+		t.Fatalf("unexpected type: %s", m.Type)
+	}
+	if m.ContentType != "application/json" {
+		t.Fatalf("unexpected content type: %s", m.ContentType)
+	}
+	if string(m.Body) != `{"username": "foo"}` {
+		t.Fatalf("unexpected body: %s", m.Body)
+	}
+}
+
+func testDecodeStructuredBase64CloudEvent(t *testing.T) {
+	m := New(nil, []byte(`{
+		"specversion": "1.0",
+		"id": "1",
+		"source": "/users",
+		"type": "user.created",
+		"datacontenttype": "text/plain",
+		"data_base64": "Zm9v"
+	}`))
+	m.ContentType = CloudEventsContentType
+
+	if err := DecodeCloudEvent(m); err != nil {
+		t.Fatalf("expected to decode cloud event: %v", err)
+	}
+	if m.ContentType != "text/plain" {
+		t.Fatalf("unexpected content type: %s", m.ContentType)
+	}
+	if string(m.Body) != "foo" {
+		t.Fatalf("unexpected body: %s", m.Body)
+	}
+}
+
+func testFailToDecodeMalformedCloudEvent(t *testing.T) {
+	m := New(nil, []byte(`INVALID`))
+	m.ContentType = CloudEventsContentType
+
+	err := DecodeCloudEvent(m)
+	if err == nil {
+		t.Fatal("expected to return err but got nil")
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.Is(err, ErrInvalidCloudEvent) || !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected ErrInvalidCloudEvent with its cause: %v", err)
+	}
+}
+
+func testFailToDecodeBinaryMissingAttributes(t *testing.T) {
+	m := New(nil, []byte(`{}`))
+	m.Headers["cloudEvents:specversion"] = "1.0"
+	m.Headers["cloudEvents:id"] = "1"
+
+	err := DecodeCloudEvent(m)
+	if err == nil {
+		t.Fatal("expected to return err but got nil")
+	}
+	if !errors.Is(err, ErrInvalidCloudEvent) || err.Error() != ErrInvalidCloudEvent.Error()+": missing source attribute" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func testKeepPlainMessage(t *testing.T) {
+	d := amqp.Delivery{
+		MessageId: "1",
+		Type:      "user.created",
+		Body:      []byte(`{"username":"foo"}`),
+	}
+
+	m, err := FromDelivery(d)
+	if err != nil {
+		t.Fatalf("expected to create message: %v", err)
+	}
+	if m.ID != "1" {
+		t.Fatalf("unexpected id: %s", m.ID)
+	}
+	if m.Type != "user.created" {
+		t.Fatalf("unexpected type: %s", m.Type)
+	}
+	if string(m.Body) != `{"username":"foo"}` {
+		t.Fatalf("unexpected body: %s", m.Body)
+	}
+}
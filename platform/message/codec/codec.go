@@ -0,0 +1,183 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sync"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+)
+
+const (
+	// DefaultContentType is assumed when a message carries no content type.
+	DefaultContentType = "application/json"
+	// DefaultMaxSize bounds the size of a decompressed body unless configured otherwise.
+	DefaultMaxSize = 16 << 20
+)
+
+var (
+	// ErrUnsupportedContentType is returned when there is no codec registered for a content type.
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	// ErrUnsupportedContentEncoding is returned when there is no decompressor registered for a content encoding.
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	// ErrBodyTooLarge is returned when a body decompresses to more than the registry maximum size.
+	ErrBodyTooLarge = errors.New("decompressed body too large")
+
+	// Default is the registry with all the built-in codecs and decompressors.
+	Default = NewRegistry()
+)
+
+type (
+	// Codec decodes message bodies of a given content type.
+	Codec interface {
+		// Decode decodes data into v.
+		Decode(data []byte, v interface{}) error
+	}
+
+	// Decompressor wraps a reader decompressing a given content encoding.
+	Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+	// Registry holds the codecs keyed on content type and the decompressors keyed on content encoding.
+	Registry struct {
+		mu            sync.RWMutex
+		codecs        map[string]Codec
+		decompressors map[string]Decompressor
+		maxSize       int64
+	}
+)
+
+// NewRegistry returns a registry configured with the built-in codecs and decompressors.
+func NewRegistry() *Registry {
+	r := &Registry{
+		codecs:        make(map[string]Codec),
+		decompressors: make(map[string]Decompressor),
+		maxSize:       DefaultMaxSize,
+	}
+
+	r.Register("application/json", JSON{})
+	r.Register("text/json", JSON{})
+	r.Register("application/msgpack", MsgPack{})
+	r.Register("application/x-msgpack", MsgPack{})
+	r.Register("application/protobuf", Protobuf{})
+	r.Register("application/x-protobuf", Protobuf{})
+	r.RegisterEncoding("gzip", Gzip)
+	r.RegisterEncoding("zstd", Zstd)
+
+	return r
+}
+
+// Register adds a codec for the given content type, replacing any previous one.
+func (r *Registry) Register(contentType string, c Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.codecs[contentType] = c
+}
+
+// RegisterEncoding adds a decompressor for the given content encoding, replacing any previous one.
+func (r *Registry) RegisterEncoding(encoding string, d Decompressor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.decompressors[encoding] = d
+}
+
+// SetMaxSize bounds the size a body may decompress to, so a small compressed body can't exhaust the memory.
+func (r *Registry) SetMaxSize(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxSize = n
+}
+
+// Clone returns a copy of the registry, so codecs can be overridden without affecting r.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c := &Registry{
+		codecs:        make(map[string]Codec, len(r.codecs)),
+		decompressors: make(map[string]Decompressor, len(r.decompressors)),
+		maxSize:       r.maxSize,
+	}
+	for k, v := range r.codecs {
+		c.codecs[k] = v
+	}
+	for k, v := range r.decompressors {
+		c.decompressors[k] = v
+	}
+
+	return c
+}
+
+// Lookup returns the codec registered for the given content type.
+// Parameters such as charset are ignored.
+func (r *Registry) Lookup(contentType string) (Codec, error) {
+	mediaType := DefaultContentType
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnsupportedContentType, err)
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.codecs[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, mediaType)
+	}
+
+	return c, nil
+}
+
+// Decode decompresses the message body according to its content encoding
+// and decodes it into v according to its content type.
+func (r *Registry) Decode(m *message.Message, v interface{}) error {
+	c, err := r.Lookup(m.ContentType)
+	if err != nil {
+		return err
+	}
+
+	body, err := r.decompress(m.ContentEncoding, m.Body)
+	if err != nil {
+		return err
+	}
+
+	return c.Decode(body, v)
+}
+
+func (r *Registry) decompress(encoding string, data []byte) ([]byte, error) {
+	if encoding == "" || encoding == "identity" {
+		return data, nil
+	}
+
+	r.mu.RLock()
+	d, ok := r.decompressors[encoding]
+	maxSize := r.maxSize
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, encoding)
+	}
+
+	rc, err := d(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// reads one byte past the maximum to tell a body of exactly the maximum size from a larger one.
+	body, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,187 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *Registry)
+	}{
+		{
+			"decode json by default",
+			testDecodeJSONByDefault,
+		},
+		{
+			"decode json with charset",
+			testDecodeJSONWithCharset,
+		},
+		{
+			"decode msgpack",
+			testDecodeMsgPack,
+		},
+		{
+			"decode gzip compressed json",
+			testDecodeGzip,
+		},
+		{
+			"decode zstd compressed json",
+			testDecodeZstd,
+		},
+		{
+			"fail to decompress body larger than max size",
+			testFailDecompressTooLarge,
+		},
+		{
+			"fail to decode unsupported content type",
+			testFailUnsupportedContentType,
+		},
+		{
+			"fail to decode unsupported content encoding",
+			testFailUnsupportedContentEncoding,
+		},
+		{
+			"fail to decode protobuf into non proto message",
+			testFailProtobufNonProtoMessage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t, NewRegistry())
+		})
+	}
+}
+
+var body = []byte(`{"email": "foo@mail.com", "username": "foo"}`)
+
+func testDecodeJSONByDefault(t *testing.T, r *Registry) {
+	user := new(srv.User)
+	if err := r.Decode(message.New(nil, body), user); err != nil {
+		t.Fatalf("expected to decode: %v", err)
+	}
+	assertUser(t, user)
+}
+
+func testDecodeJSONWithCharset(t *testing.T, r *Registry) {
+	m := message.New(nil, body)
+	m.ContentType = "application/json; charset=utf-8"
+
+	user := new(srv.User)
+	if err := r.Decode(m, user); err != nil {
+		t.Fatalf("expected to decode: %v", err)
+	}
+	assertUser(t, user)
+}
+
+func testDecodeMsgPack(t *testing.T, r *Registry) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(&srv.User{Email: "foo@mail.com", Username: "foo"}); err != nil {
+		t.Fatalf("failed to encode msgpack: %v", err)
+	}
+	m := message.New(nil, buf.Bytes())
+	m.ContentType = "application/msgpack"
+
+	user := new(srv.User)
+	if err := r.Decode(m, user); err != nil {
+		t.Fatalf("expected to decode: %v", err)
+	}
+	assertUser(t, user)
+}
+
+func testDecodeGzip(t *testing.T, r *Registry) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(body)
+	w.Close()
+	m := message.New(nil, buf.Bytes())
+	m.ContentType = "application/json"
+	m.ContentEncoding = "gzip"
+
+	user := new(srv.User)
+	if err := r.Decode(m, user); err != nil {
+		t.Fatalf("expected to decode: %v", err)
+	}
+	assertUser(t, user)
+}
+
+func testFailDecompressTooLarge(t *testing.T, r *Registry) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(body)
+	w.Close()
+	m := message.New(nil, buf.Bytes())
+	m.ContentEncoding = "gzip"
+
+	r.SetMaxSize(int64(len(body)))
+	if err := r.Decode(m, new(srv.User)); err != nil {
+		t.Fatalf("expected to decode body of max size: %v", err)
+	}
+
+	r.SetMaxSize(int64(len(body) - 1))
+	if err := r.Decode(m, new(srv.User)); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("expected to have ErrBodyTooLarge: %v", err)
+	}
+}
+
+func testDecodeZstd(t *testing.T, r *Registry) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("failed to create zstd writer: %v", err)
+	}
+	m := message.New(nil, enc.EncodeAll(body, nil))
+	m.ContentEncoding = "zstd"
+
+	user := new(srv.User)
+	if err := r.Decode(m, user); err != nil {
+		t.Fatalf("expected to decode: %v", err)
+	}
+	assertUser(t, user)
+}
+
+func testFailUnsupportedContentType(t *testing.T, r *Registry) {
+	m := message.New(nil, body)
+	m.ContentType = "text/plain"
+
+	if err := r.Decode(m, new(srv.User)); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Fatalf("expected to have ErrUnsupportedContentType: %v", err)
+	}
+}
+
+func testFailUnsupportedContentEncoding(t *testing.T, r *Registry) {
+	m := message.New(nil, body)
+	m.ContentEncoding = "br"
+
+	if err := r.Decode(m, new(srv.User)); !errors.Is(err, ErrUnsupportedContentEncoding) {
+		t.Fatalf("expected to have ErrUnsupportedContentEncoding: %v", err)
+	}
+}
+
+func testFailProtobufNonProtoMessage(t *testing.T, r *Registry) {
+	m := message.New(nil, body)
+	m.ContentType = "application/x-protobuf"
+
+	if err := r.Decode(m, new(srv.User)); !errors.Is(err, ErrNotProtoMessage) {
+		t.Fatalf("expected to have ErrNotProtoMessage: %v", err)
+	}
+}
+
+func assertUser(t *testing.T, user *srv.User) {
+	if user.Email != "foo@mail.com" {
+		t.Fatalf("unexpected email: %s", user.Email)
+	}
+	if user.Username != "foo" {
+		t.Fatalf("unexpected username: %s", user.Username)
+	}
+}
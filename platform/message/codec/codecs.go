@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrNotProtoMessage is returned when decoding protobuf into a value which is not a proto.Message.
+	ErrNotProtoMessage = errors.New("value is not a proto message")
+)
+
+type (
+	// JSON is the application/json codec.
+	JSON struct{}
+	// MsgPack is the application/msgpack codec.
+	MsgPack struct{}
+	// Protobuf is the application/x-protobuf codec.
+	Protobuf struct{}
+)
+
+// Decode decodes json data into v.
+func (JSON) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Decode decodes msgpack data into v.
+// Struct fields are matched on their json tags, so the same types serve both codecs.
+func (MsgPack) Decode(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// Decode decodes protobuf data into v, which must be a proto.Message.
+func (Protobuf) Decode(data []byte, v interface{}) error {
+	pm, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+
+	return proto.Unmarshal(data, pm)
+}
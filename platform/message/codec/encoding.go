@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Gzip decompresses gzip encoded bodies.
+func Gzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Zstd decompresses zstd encoded bodies.
+func Zstd(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.IOReadCloser(), nil
+}
@@ -10,10 +10,21 @@ type (
 	// Consumer is a method for binding routingKey, exchange and creating the amqp queue.
 	Consumer interface {
 		Consume(routingKey, exchange string) (<-chan amqp.Delivery, error)
+		// Cancel stops consuming the routing key, closing its deliveries channel.
+		Cancel(routingKey string) error
+		// Qos limits how many deliveries are sent ahead of being acknowledged, applied on the next Consume.
+		Qos(prefetch int) error
 	}
 
 	// Handler is the message handler.
 	Handler interface {
 		Handle(context context.Context, msg *Message) error
 	}
+
+	// BatchHandler is implemented by handlers which can handle messages in batches.
+	BatchHandler interface {
+		// HandleBatch handles the messages, returning the error of each one. Acks are deferred
+		// until the whole batch is handled and the messages left unsettled are nacked.
+		HandleBatch(ctx context.Context, msgs []*Message) []error
+	}
 )
@@ -0,0 +1,26 @@
+package message
+
+import "github.com/streadway/amqp"
+
+// FromDelivery creates a new application message from an amqp delivery.
+// The message metadata is taken from the amqp properties and overridden by
+// the CloudEvents attributes when the delivery carries a CloudEvent.
+func FromDelivery(d amqp.Delivery) (*Message, error) {
+	m := New(d, d.Body)
+	for k, v := range d.Headers {
+		m.Headers[k] = v
+	}
+	m.ContentType = d.ContentType
+	m.ContentEncoding = d.ContentEncoding
+	m.RoutingKey = d.RoutingKey
+	m.ID = d.MessageId
+	m.Type = d.Type
+	m.Source = d.AppId
+	m.Time = d.Timestamp
+
+	if err := DecodeCloudEvent(m); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
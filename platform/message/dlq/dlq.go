@@ -0,0 +1,219 @@
+package dlq
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+type (
+	// Queue is a dead letter queue.
+	Queue interface {
+		// Get returns the next delivery, ok is false when the queue is empty.
+		Get() (d amqp.Delivery, ok bool, err error)
+	}
+
+	// Publisher publishes messages to an exchange.
+	Publisher interface {
+		Publish(exchange, key string, p amqp.Publishing) error
+	}
+
+	// Filter selects dead-lettered messages, empty fields match any message.
+	Filter struct {
+		RoutingKey string
+		Reason     string
+		MessageIDs []string
+	}
+
+	// Message is a dead-lettered message along with the failure details from its x-death headers.
+	Message struct {
+		Exchange        string                 `json:"exchange"`
+		RoutingKey      string                 `json:"routing_key"`
+		Queue           string                 `json:"queue,omitempty"`
+		Reason          string                 `json:"reason,omitempty"`
+		Count           int64                  `json:"count,omitempty"`
+		Time            time.Time              `json:"time"`
+		MessageID       string                 `json:"message_id,omitempty"`
+		CorrelationID   string                 `json:"correlation_id,omitempty"`
+		ContentType     string                 `json:"content_type,omitempty"`
+		ContentEncoding string                 `json:"content_encoding,omitempty"`
+		Headers         map[string]interface{} `json:"headers,omitempty"`
+		Body            []byte                 `json:"body_base64"`
+
+		delivery amqp.Delivery
+		settled  bool
+	}
+)
+
+// Fetch gets up to limit messages from q. The messages stay in the queue until
+// replayed, and must be released once done with them.
+func Fetch(q Queue, limit int) ([]*Message, error) {
+	var msgs []*Message
+	for limit <= 0 || len(msgs) < limit {
+		d, ok, err := q.Get()
+		if err != nil {
+			Release(msgs)
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, newMessage(d))
+	}
+
+	return msgs, nil
+}
+
+// Select returns the messages matching f.
+func Select(msgs []*Message, f Filter) []*Message {
+	var selected []*Message
+	for _, m := range msgs {
+		if f.match(m) {
+			selected = append(selected, m)
+		}
+	}
+
+	return selected
+}
+
+// Replay publishes the messages to their original exchange and routing key,
+// removing them from the dead letter queue.
+func Replay(p Publisher, msgs []*Message) error {
+	for _, m := range msgs {
+		if m.settled {
+			continue
+		}
+		if err := p.Publish(m.Exchange, m.RoutingKey, m.Publishing()); err != nil {
+			return err
+		}
+		if err := m.delivery.Ack(false); err != nil {
+			return err
+		}
+		m.settled = true
+	}
+
+	return nil
+}
+
+// Dump writes the messages to w, one JSON object per line.
+func Dump(w io.Writer, msgs []*Message) error {
+	enc := json.NewEncoder(w)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Release returns the messages which were not replayed to the dead letter queue.
+func Release(msgs []*Message) error {
+	var first error
+	for _, m := range msgs {
+		if m.settled {
+			continue
+		}
+		if err := m.delivery.Nack(false, true); err != nil && first == nil {
+			first = err
+		}
+		m.settled = true
+	}
+
+	return first
+}
+
+// Publishing returns the message as it was originally published, without the dead letter headers.
+func (m *Message) Publishing() amqp.Publishing {
+	headers := make(amqp.Table, len(m.Headers))
+	for k, v := range m.Headers {
+		if k == "x-death" || strings.HasPrefix(k, "x-first-death-") || strings.HasPrefix(k, "x-last-death-") {
+			continue
+		}
+		headers[k] = v
+	}
+
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   m.CorrelationID,
+		MessageId:       m.MessageID,
+		Timestamp:       m.delivery.Timestamp,
+		Type:            m.delivery.Type,
+		AppId:           m.delivery.AppId,
+		Body:            m.Body,
+	}
+}
+
+func newMessage(d amqp.Delivery) *Message {
+	m := &Message{
+		Exchange:        d.Exchange,
+		RoutingKey:      d.RoutingKey,
+		MessageID:       d.MessageId,
+		CorrelationID:   d.CorrelationId,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		Headers:         d.Headers,
+		Body:            d.Body,
+		delivery:        d,
+	}
+
+	// the first x-death entry is the most recent death, its exchange and
+	// routing keys are the ones the message was originally published to.
+	deaths, _ := d.Headers["x-death"].([]interface{})
+	if len(deaths) == 0 {
+		return m
+	}
+	death, _ := deaths[0].(amqp.Table)
+	m.Queue, _ = death["queue"].(string)
+	m.Reason, _ = death["reason"].(string)
+	m.Time, _ = death["time"].(time.Time)
+	m.Count = count(death["count"])
+	if ex, ok := death["exchange"].(string); ok {
+		m.Exchange = ex
+	}
+	if keys, _ := death["routing-keys"].([]interface{}); len(keys) > 0 {
+		if key, ok := keys[0].(string); ok {
+			m.RoutingKey = key
+		}
+	}
+
+	return m
+}
+
+func (f Filter) match(m *Message) bool {
+	if f.RoutingKey != "" && f.RoutingKey != m.RoutingKey {
+		return false
+	}
+	if f.Reason != "" && f.Reason != m.Reason {
+		return false
+	}
+	if len(f.MessageIDs) == 0 {
+		return true
+	}
+	for _, id := range f.MessageIDs {
+		if id == m.MessageID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func count(v interface{}) int64 {
+	switch c := v.(type) {
+	case int64:
+		return c
+	case int32:
+		return int64(c)
+	case int:
+		return int64(c)
+	}
+
+	return 0
+}
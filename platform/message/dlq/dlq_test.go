@@ -0,0 +1,141 @@
+package dlq
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message/inmem"
+	"github.com/streadway/amqp"
+)
+
+func TestDeadLetterQueue(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *inmem.Broker)
+	}{
+		{
+			"fetch dead-lettered messages",
+			testFetch,
+		},
+		{
+			"select by routing key and reason",
+			testSelect,
+		},
+		{
+			"replay to original routing key",
+			testReplay,
+		},
+		{
+			"dump messages",
+			testDump,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			b := inmem.NewBroker()
+			deadLetter(t, b, "user.created", "1")
+			deadLetter(t, b, "user.email.changed", "2")
+			test.function(t, b)
+		})
+	}
+}
+
+func testFetch(t *testing.T, b *inmem.Broker) {
+	msgs, err := Fetch(b.DeadLetters(), 0)
+	if err != nil {
+		t.Fatalf("expected to fetch: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("unexpected messages: %d", len(msgs))
+	}
+
+	m := msgs[0]
+	if m.Exchange != "users" || m.RoutingKey != "user.created" {
+		t.Fatalf("unexpected origin: %s %s", m.Exchange, m.RoutingKey)
+	}
+	if m.Reason != "rejected" || m.Count != 1 || m.Time.IsZero() {
+		t.Fatalf("unexpected failure: %s %d %s", m.Reason, m.Count, m.Time)
+	}
+
+	if err := Release(msgs); err != nil {
+		t.Fatalf("expected to release: %v", err)
+	}
+	if b.DeadLetters().Len() != 2 {
+		t.Fatalf("unexpected dead letters: %d", b.DeadLetters().Len())
+	}
+}
+
+func testSelect(t *testing.T, b *inmem.Broker) {
+	msgs, _ := Fetch(b.DeadLetters(), 0)
+	defer Release(msgs)
+
+	if n := len(Select(msgs, Filter{RoutingKey: "user.created"})); n != 1 {
+		t.Fatalf("unexpected selected by routing key: %d", n)
+	}
+	if n := len(Select(msgs, Filter{Reason: "expired"})); n != 0 {
+		t.Fatalf("unexpected selected by reason: %d", n)
+	}
+	if n := len(Select(msgs, Filter{MessageIDs: []string{"2"}})); n != 1 {
+		t.Fatalf("unexpected selected by message id: %d", n)
+	}
+}
+
+func testReplay(t *testing.T, b *inmem.Broker) {
+	msgchan, _ := b.Consume("user.created", "users")
+
+	msgs, _ := Fetch(b.DeadLetters(), 0)
+	if err := Replay(b, Select(msgs, Filter{RoutingKey: "user.created"})); err != nil {
+		t.Fatalf("expected to replay: %v", err)
+	}
+	Release(msgs)
+
+	d := <-msgchan
+	if d.MessageId != "1" {
+		t.Fatalf("unexpected message id: %s", d.MessageId)
+	}
+	if _, ok := d.Headers["x-death"]; ok {
+		t.Fatal("expected x-death header to be removed")
+	}
+	if d.Headers["attempt"] != "1" {
+		t.Fatalf("unexpected headers: %v", d.Headers)
+	}
+	if b.DeadLetters().Len() != 1 {
+		t.Fatalf("unexpected dead letters: %d", b.DeadLetters().Len())
+	}
+}
+
+func testDump(t *testing.T, b *inmem.Broker) {
+	msgs, _ := Fetch(b.DeadLetters(), 1)
+	defer Release(msgs)
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, msgs); err != nil {
+		t.Fatalf("expected to dump: %v", err)
+	}
+
+	var m Message
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("expected to decode dump: %v", err)
+	}
+	if m.RoutingKey != "user.created" || string(m.Body) != `{"id":1}` {
+		t.Fatalf("unexpected message: %+v", m)
+	}
+}
+
+// deadLetter publishes a message and rejects it without requeue.
+func deadLetter(t *testing.T, b *inmem.Broker, key, id string) {
+	msgchan, _ := b.Consume(key, "users")
+	b.Publish("users", key, amqp.Publishing{
+		MessageId: id,
+		Headers:   amqp.Table{"attempt": "1"},
+		Body:      []byte(`{"id":` + id + `}`),
+	})
+
+	d := <-msgchan
+	if err := d.Reject(false); err != nil {
+		t.Fatalf("expected to reject: %v", err)
+	}
+	b.Cancel(key)
+}
@@ -0,0 +1,328 @@
+package inmem
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/streadway/amqp"
+)
+
+const bufferSize = 128
+
+var (
+	// make sure Broker satisfies message.Consumer interface.
+	_ message.Consumer = (*Broker)(nil)
+	// make sure Broker satisfies amqp.Acknowledger interface.
+	_ amqp.Acknowledger = (*Broker)(nil)
+
+	// ErrUnknownDeliveryTag is returned when acknowledging a delivery the broker is not aware of.
+	ErrUnknownDeliveryTag = errors.New("unknown delivery tag")
+)
+
+type (
+	// Broker is an in memory message broker, useful for tests and local development.
+	Broker struct {
+		mu       sync.Mutex
+		tag      uint64
+		bindings map[binding]*queue
+		unacked  map[uint64]delivery
+		acked    []amqp.Delivery
+		dead     []amqp.Delivery
+	}
+
+	binding struct {
+		exchange string
+		key      string
+	}
+
+	// queue holds the consumers of a binding, and the deliveries waiting for one while there is none.
+	queue struct {
+		consumers []*consumer
+		pending   []amqp.Delivery
+	}
+
+	consumer struct {
+		mu      sync.Mutex
+		ch      chan amqp.Delivery
+		done    chan struct{}
+		sending sync.WaitGroup
+		closed  bool
+	}
+
+	delivery struct {
+		amqp.Delivery
+		binding    binding
+		to         *consumer
+		deadLetter bool
+	}
+)
+
+// NewBroker returns a new in memory broker.
+func NewBroker() *Broker {
+	return &Broker{
+		bindings: make(map[binding]*queue),
+		unacked:  make(map[uint64]delivery),
+	}
+}
+
+// Consume binds a new consumer to the given routing key and exchange.
+// Deliveries waiting since the binding consumers were cancelled are sent to it.
+func (b *Broker) Consume(key, exchange string) (<-chan amqp.Delivery, error) {
+	b.mu.Lock()
+	c := &consumer{ch: make(chan amqp.Delivery, bufferSize), done: make(chan struct{})}
+	bind := binding{exchange, key}
+	q, ok := b.bindings[bind]
+	if !ok {
+		q = new(queue)
+		b.bindings[bind] = q
+	}
+	q.consumers = append(q.consumers, c)
+	pending := q.pending
+	q.pending = nil
+	b.mu.Unlock()
+
+	for _, d := range pending {
+		b.dispatch(delivery{Delivery: d, binding: bind, to: c})
+	}
+
+	return c.ch, nil
+}
+
+// Cancel closes the consumers bound to the given routing key. Their binding is kept,
+// so deliveries published meanwhile wait for the next consumer.
+func (b *Broker) Cancel(key string) error {
+	b.mu.Lock()
+	var cancelled []*consumer
+	for bind, q := range b.bindings {
+		if bind.key == key {
+			cancelled = append(cancelled, q.consumers...)
+			q.consumers = nil
+		}
+	}
+	b.mu.Unlock()
+
+	for _, c := range cancelled {
+		c.close()
+	}
+
+	return nil
+}
+
+// Qos is a no-op, the broker does not limit unacknowledged deliveries.
+func (b *Broker) Qos(prefetch int) error {
+	return nil
+}
+
+// Publish delivers the publishing to every consumer bound to the given exchange and routing key.
+func (b *Broker) Publish(exchange, key string, p amqp.Publishing) error {
+	b.mu.Lock()
+	bind := binding{exchange, key}
+	q, ok := b.bindings[bind]
+	if !ok {
+		b.mu.Unlock()
+		return nil
+	}
+	if len(q.consumers) == 0 {
+		b.tag++
+		q.pending = append(q.pending, publishing(b, bind, b.tag, p))
+		b.mu.Unlock()
+		return nil
+	}
+
+	deliveries := make([]delivery, 0, len(q.consumers))
+	for _, c := range q.consumers {
+		b.tag++
+		d := delivery{Delivery: publishing(b, bind, b.tag, p), binding: bind, to: c}
+		b.unacked[d.DeliveryTag] = d
+		deliveries = append(deliveries, d)
+	}
+	b.mu.Unlock()
+
+	for _, d := range deliveries {
+		if !d.to.send(d.Delivery) {
+			b.redispatch(d)
+		}
+	}
+
+	return nil
+}
+
+// Acked returns the deliveries acknowledged so far.
+func (b *Broker) Acked() []amqp.Delivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]amqp.Delivery(nil), b.acked...)
+}
+
+// Unacked returns the number of deliveries waiting for acknowledgement.
+func (b *Broker) Unacked() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.unacked)
+}
+
+// Ack acknowledges the delivery with the given tag.
+func (b *Broker) Ack(tag uint64, multiple bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ds := b.take(tag, multiple)
+	for _, d := range ds {
+		b.acked = append(b.acked, d.Delivery)
+	}
+	if len(ds) == 0 && !multiple {
+		return ErrUnknownDeliveryTag
+	}
+
+	return nil
+}
+
+// Nack negatively acknowledges the delivery with the given tag.
+// Unless requeued, the delivery is dead-lettered.
+func (b *Broker) Nack(tag uint64, multiple, requeue bool) error {
+	b.mu.Lock()
+	ds := b.take(tag, multiple)
+	if !requeue {
+		b.deadLetter(ds)
+	}
+	b.mu.Unlock()
+
+	if len(ds) == 0 && !multiple {
+		return ErrUnknownDeliveryTag
+	}
+	if requeue {
+		b.requeue(ds)
+	}
+
+	return nil
+}
+
+// Reject negatively acknowledges the delivery with the given tag.
+func (b *Broker) Reject(tag uint64, requeue bool) error {
+	return b.Nack(tag, false, requeue)
+}
+
+// take removes and returns the unacked deliveries matching tag.
+func (b *Broker) take(tag uint64, multiple bool) []delivery {
+	var ds []delivery
+	for t, d := range b.unacked {
+		if t == tag || (multiple && t < tag) {
+			ds = append(ds, d)
+			delete(b.unacked, t)
+		}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].DeliveryTag < ds[j].DeliveryTag })
+
+	return ds
+}
+
+func (b *Broker) requeue(ds []delivery) {
+	var dead []amqp.Delivery
+	for _, d := range ds {
+		d.Redelivered = true
+		if d.deadLetter {
+			dead = append(dead, d.Delivery)
+			continue
+		}
+		b.dispatch(d)
+	}
+
+	if len(dead) > 0 {
+		b.mu.Lock()
+		b.dead = append(dead, b.dead...)
+		b.mu.Unlock()
+	}
+}
+
+// dispatch sends d to its consumer with a new delivery tag.
+func (b *Broker) dispatch(d delivery) {
+	b.mu.Lock()
+	b.tag++
+	d.DeliveryTag = b.tag
+	b.unacked[d.DeliveryTag] = d
+	b.mu.Unlock()
+
+	if !d.to.send(d.Delivery) {
+		b.redispatch(d)
+	}
+}
+
+// redispatch sends d to another consumer of its binding as its consumer was cancelled,
+// or keeps it pending when there is none.
+func (b *Broker) redispatch(d delivery) {
+	b.mu.Lock()
+	delete(b.unacked, d.DeliveryTag)
+	q := b.bindings[d.binding]
+	if len(q.consumers) == 0 {
+		q.pending = append(q.pending, d.Delivery)
+		b.mu.Unlock()
+		return
+	}
+	d.to = q.consumers[0]
+	b.mu.Unlock()
+
+	b.dispatch(d)
+}
+
+// publishing returns the delivery of p through the given binding.
+func publishing(ac amqp.Acknowledger, bind binding, tag uint64, p amqp.Publishing) amqp.Delivery {
+	return amqp.Delivery{
+		Acknowledger:    ac,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		DeliveryTag:     tag,
+		Exchange:        bind.exchange,
+		RoutingKey:      bind.key,
+		Body:            p.Body,
+	}
+}
+
+// send delivers d unless the consumer is cancelled, giving up once it is while waiting for room
+// in its channel. It doesn't hold the lock meanwhile, so a full channel doesn't block close.
+func (c *consumer) send(d amqp.Delivery) bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	c.sending.Add(1)
+	c.mu.Unlock()
+	defer c.sending.Done()
+
+	select {
+	case c.ch <- d:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// close cancels the consumer, closing its channel once the pending sends gave up.
+func (c *consumer) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.done)
+	c.mu.Unlock()
+
+	c.sending.Wait()
+	close(c.ch)
+}
@@ -0,0 +1,168 @@
+package inmem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/streadway/amqp"
+)
+
+func TestBroker(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *Broker)
+	}{
+		{
+			"deliver and ack message",
+			testDeliverAndAck,
+		},
+		{
+			"redeliver requeued message",
+			testRedeliverRequeued,
+		},
+		{
+			"decode cloud event",
+			testDecodeCloudEvent,
+		},
+		{
+			"keep messages published while cancelled",
+			testKeepMessagesWhileCancelled,
+		},
+		{
+			"cancel consumer with a full channel",
+			testCancelFullConsumer,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t, NewBroker())
+		})
+	}
+}
+
+func testDeliverAndAck(t *testing.T, b *Broker) {
+	msgchan, err := b.Consume("user.created", "users")
+	if err != nil {
+		t.Fatalf("expected to consume: %v", err)
+	}
+	if err := b.Publish("users", "user.created", amqp.Publishing{Body: []byte(`foo`)}); err != nil {
+		t.Fatalf("expected to publish: %v", err)
+	}
+
+	d := <-msgchan
+	if string(d.Body) != "foo" {
+		t.Fatalf("unexpected body: %s", d.Body)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("expected to ack: %v", err)
+	}
+	if len(b.Acked()) != 1 {
+		t.Fatalf("unexpected acked count: %d", len(b.Acked()))
+	}
+	if b.Unacked() != 0 {
+		t.Fatalf("unexpected unacked count: %d", b.Unacked())
+	}
+	if err := d.Ack(false); err != ErrUnknownDeliveryTag {
+		t.Fatalf("expected to have ErrUnknownDeliveryTag: %v", err)
+	}
+}
+
+func testRedeliverRequeued(t *testing.T, b *Broker) {
+	msgchan, _ := b.Consume("user.created", "users")
+	b.Publish("users", "user.created", amqp.Publishing{Body: []byte(`foo`)})
+
+	d := <-msgchan
+	if err := d.Nack(false, true); err != nil {
+		t.Fatalf("expected to nack: %v", err)
+	}
+
+	d = <-msgchan
+	if !d.Redelivered {
+		t.Fatal("expected message to be redelivered")
+	}
+	if b.Unacked() != 1 {
+		t.Fatalf("unexpected unacked count: %d", b.Unacked())
+	}
+}
+
+func testDecodeCloudEvent(t *testing.T, b *Broker) {
+	msgchan, _ := b.Consume("user.created", "users")
+	b.Publish("users", "user.created", amqp.Publishing{
+		ContentType: message.CloudEventsContentType,
+		Body:        []byte(`{"specversion":"1.0","id":"1","source":"/users","type":"user.created","data":{}}`),
+	})
+
+	m, err := message.FromDelivery(<-msgchan)
+	if err != nil {
+		t.Fatalf("expected to decode cloud event: %v", err)
+	}
+	if m.ID != "1" {
+		t.Fatalf("unexpected id: %s", m.ID)
+	}
+	if string(m.Body) != "{}" {
+		t.Fatalf("unexpected body: %s", m.Body)
+	}
+}
+
+func testKeepMessagesWhileCancelled(t *testing.T, b *Broker) {
+	msgchan, _ := b.Consume("user.created", "users")
+	if err := b.Cancel("user.created"); err != nil {
+		t.Fatalf("expected to cancel: %v", err)
+	}
+	if _, ok := <-msgchan; ok {
+		t.Fatal("expected messages channel to be closed")
+	}
+
+	b.Publish("users", "user.created", amqp.Publishing{Body: []byte(`foo`)})
+	msgchan, _ = b.Consume("user.created", "users")
+
+	d := <-msgchan
+	if string(d.Body) != "foo" {
+		t.Fatalf("unexpected body: %s", d.Body)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("expected to ack: %v", err)
+	}
+}
+
+func testCancelFullConsumer(t *testing.T, b *Broker) {
+	msgchan, _ := b.Consume("user.created", "users")
+	for i := 0; i < bufferSize; i++ {
+		b.Publish("users", "user.created", amqp.Publishing{Body: []byte(`foo`)})
+	}
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		b.Publish("users", "user.created", amqp.Publishing{Body: []byte(`bar`)})
+	}()
+
+	cancelled := make(chan error)
+	go func() { cancelled <- b.Cancel("user.created") }()
+
+	select {
+	case err := <-cancelled:
+		if err != nil {
+			t.Fatalf("expected to cancel: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected cancel not to block on the full channel")
+	}
+	<-published
+
+	n := 0
+	for range msgchan {
+		n++
+	}
+	if n != bufferSize {
+		t.Fatalf("unexpected deliveries count: %d", n)
+	}
+
+	msgchan, _ = b.Consume("user.created", "users")
+	d := <-msgchan
+	if string(d.Body) != "bar" {
+		t.Fatalf("unexpected body: %s", d.Body)
+	}
+}
@@ -0,0 +1,94 @@
+package inmem
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const rejected = "rejected"
+
+type (
+	// DeadLetters is the queue of deliveries negatively acknowledged without requeue.
+	// Deliveries are annotated with x-death headers, as RabbitMQ does.
+	DeadLetters struct {
+		b *Broker
+	}
+)
+
+// DeadLetters returns the broker dead letter queue.
+func (b *Broker) DeadLetters() *DeadLetters {
+	return &DeadLetters{b}
+}
+
+// Get returns the next dead-lettered delivery, ok is false when the queue is empty.
+// The delivery must be acknowledged to be removed from the queue.
+func (q *DeadLetters) Get() (d amqp.Delivery, ok bool, err error) {
+	q.b.mu.Lock()
+	defer q.b.mu.Unlock()
+
+	if len(q.b.dead) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+
+	d = q.b.dead[0]
+	q.b.dead = q.b.dead[1:]
+	q.b.tag++
+	d.DeliveryTag = q.b.tag
+	q.b.unacked[d.DeliveryTag] = delivery{Delivery: d, deadLetter: true}
+
+	return d, true, nil
+}
+
+// Len returns the number of deliveries in the queue.
+func (q *DeadLetters) Len() int {
+	q.b.mu.Lock()
+	defer q.b.mu.Unlock()
+
+	return len(q.b.dead)
+}
+
+// deadLetter moves ds to the dead letter queue, b.mu must be held.
+// Dead letters rejected again are dropped.
+func (b *Broker) deadLetter(ds []delivery) {
+	for _, d := range ds {
+		if d.deadLetter {
+			continue
+		}
+		d.Headers = death(d.Delivery)
+		b.dead = append(b.dead, d.Delivery)
+	}
+}
+
+// death returns the delivery headers with an x-death entry for its rejection.
+func death(d amqp.Delivery) amqp.Table {
+	headers := make(amqp.Table, len(d.Headers)+4)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+
+	deaths, _ := headers["x-death"].([]interface{})
+	if len(deaths) > 0 {
+		if last, ok := deaths[0].(amqp.Table); ok && last["queue"] == d.RoutingKey && last["reason"] == rejected {
+			count, _ := last["count"].(int64)
+			last["count"] = count + 1
+			return headers
+		}
+	}
+
+	headers["x-death"] = append([]interface{}{amqp.Table{
+		"count":        int64(1),
+		"reason":       rejected,
+		"queue":        d.RoutingKey,
+		"exchange":     d.Exchange,
+		"routing-keys": []interface{}{d.RoutingKey},
+		"time":         time.Now().UTC().Truncate(time.Second),
+	}}, deaths...)
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = rejected
+		headers["x-first-death-queue"] = d.RoutingKey
+		headers["x-first-death-exchange"] = d.Exchange
+	}
+
+	return headers
+}
@@ -1,5 +1,13 @@
 package message
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrParked is returned by handlers which left the message unacknowledged, to handle it later.
+var ErrParked = errors.New("message parked")
+
 type (
 	// Acknowledger expose methods for acknowledge messages
 	Acknowledger interface {
@@ -14,8 +22,23 @@ type (
 	// Message is the RabbitMQ message
 	Message struct {
 		Acknowledger
-		Headers map[string]interface{}
-		Body    []byte
+		Headers         map[string]interface{}
+		ContentType     string
+		ContentEncoding string
+		Body            []byte
+		// RoutingKey is the key the message was published with.
+		RoutingKey string
+
+		// ID identifies the event, unique per Source.
+		ID string
+		// Source identifies the context in which the event happened.
+		Source string
+		// Type describes the kind of event, e.g. user.created.
+		Type string
+		// Subject describes the subject of the event in the context of the Source.
+		Subject string
+		// Time is when the event happened.
+		Time time.Time
 	}
 )
 
@@ -0,0 +1,177 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// maxLineSize bounds the size of a single record.
+const maxLineSize = 4 << 20
+
+var (
+	// make sure Acknowledger satisfies amqp.Acknowledger interface.
+	_ amqp.Acknowledger = (*Acknowledger)(nil)
+
+	// ErrMissingRoutingKey is returned when a record has no routing key.
+	ErrMissingRoutingKey = errors.New("missing routing key")
+)
+
+// Settlements recorded by the Acknowledger.
+const (
+	Acked     = "ack"
+	Nacked    = "nack"
+	Requeued  = "nack requeue"
+	Rejected  = "reject"
+	Unsettled = "none"
+)
+
+type (
+	// Record is a message to be replayed. The body is either given in body, as a
+	// JSON string or any other JSON value used verbatim, or base64 encoded in body_base64.
+	// Messages dumped by the dlq command are valid records.
+	Record struct {
+		Exchange        string                 `json:"exchange"`
+		RoutingKey      string                 `json:"routing_key"`
+		MessageID       string                 `json:"message_id"`
+		CorrelationID   string                 `json:"correlation_id"`
+		ContentType     string                 `json:"content_type"`
+		ContentEncoding string                 `json:"content_encoding"`
+		Headers         map[string]interface{} `json:"headers"`
+		Body            json.RawMessage        `json:"body"`
+		BodyBase64      []byte                 `json:"body_base64"`
+	}
+
+	// Reader reads records from JSON lines, skipping blank ones.
+	Reader struct {
+		scanner *bufio.Scanner
+		line    int
+	}
+
+	// Acknowledger records how each delivery was settled instead of sending it to a broker.
+	Acknowledger struct {
+		mu      sync.Mutex
+		settled map[uint64]string
+	}
+)
+
+// NewReader returns a new Reader.
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &Reader{scanner: s}
+}
+
+// Read returns the next record and its line number, err is io.EOF when there are no more records.
+func (r *Reader) Read() (rec Record, line int, err error) {
+	for r.scanner.Scan() {
+		r.line++
+		if len(bytes.TrimSpace(r.scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(r.scanner.Bytes(), &rec); err != nil {
+			return rec, r.line, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		if rec.RoutingKey == "" {
+			return rec, r.line, fmt.Errorf("line %d: %v", r.line, ErrMissingRoutingKey)
+		}
+		return rec, r.line, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return rec, r.line, err
+	}
+
+	return rec, r.line, io.EOF
+}
+
+// Delivery returns the record as a delivery with the given tag, settled through ac.
+func (rec Record) Delivery(ac amqp.Acknowledger, tag uint64) (amqp.Delivery, error) {
+	body, err := rec.body()
+	if err != nil {
+		return amqp.Delivery{}, err
+	}
+
+	return amqp.Delivery{
+		Acknowledger:    ac,
+		Headers:         rec.Headers,
+		ContentType:     rec.ContentType,
+		ContentEncoding: rec.ContentEncoding,
+		CorrelationId:   rec.CorrelationID,
+		MessageId:       rec.MessageID,
+		DeliveryTag:     tag,
+		Exchange:        rec.Exchange,
+		RoutingKey:      rec.RoutingKey,
+		Body:            body,
+	}, nil
+}
+
+func (rec Record) body() ([]byte, error) {
+	if len(rec.BodyBase64) > 0 {
+		return rec.BodyBase64, nil
+	}
+
+	raw := bytes.TrimSpace(rec.Body)
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
+	}
+
+	return raw, nil
+}
+
+// NewAcknowledger returns a new Acknowledger.
+func NewAcknowledger() *Acknowledger {
+	return &Acknowledger{settled: make(map[uint64]string)}
+}
+
+// Settlement returns how the delivery with the given tag was settled.
+func (a *Acknowledger) Settlement(tag uint64) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if s, ok := a.settled[tag]; ok {
+		return s
+	}
+
+	return Unsettled
+}
+
+// Ack records the delivery as acked.
+func (a *Acknowledger) Ack(tag uint64, multiple bool) error {
+	return a.settle(tag, Acked)
+}
+
+// Nack records the delivery as nacked, or requeued.
+func (a *Acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	if requeue {
+		return a.settle(tag, Requeued)
+	}
+
+	return a.settle(tag, Nacked)
+}
+
+// Reject records the delivery as rejected, or requeued.
+func (a *Acknowledger) Reject(tag uint64, requeue bool) error {
+	if requeue {
+		return a.settle(tag, Requeued)
+	}
+
+	return a.settle(tag, Rejected)
+}
+
+func (a *Acknowledger) settle(tag uint64, s string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.settled[tag] = s
+	return nil
+}
@@ -0,0 +1,85 @@
+package replay
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T)
+	}{
+		{
+			"read records",
+			testReadRecords,
+		},
+		{
+			"fail to read record without routing key",
+			testFailReadWithoutRoutingKey,
+		},
+		{
+			"record settlements",
+			testRecordSettlements,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t)
+		})
+	}
+}
+
+func testReadRecords(t *testing.T) {
+	r := NewReader(strings.NewReader(`{"routing_key":"user.created","body":{"id":1}}
+
+{"routing_key":"user.created","content_type":"text/plain","body":"foo"}
+{"routing_key":"user.created","body_base64":"YmFy"}
+`))
+
+	bodies := []string{`{"id":1}`, "foo", "bar"}
+	lines := []int{1, 3, 4}
+	for i, expected := range bodies {
+		rec, line, err := r.Read()
+		if err != nil {
+			t.Fatalf("expected to read record: %v", err)
+		}
+		if line != lines[i] {
+			t.Fatalf("unexpected line: %d", line)
+		}
+		d, err := rec.Delivery(NewAcknowledger(), 1)
+		if err != nil {
+			t.Fatalf("expected to build delivery: %v", err)
+		}
+		if string(d.Body) != expected {
+			t.Fatalf("unexpected body: %s", d.Body)
+		}
+	}
+
+	if _, _, err := r.Read(); err != io.EOF {
+		t.Fatalf("expected to have io.EOF: %v", err)
+	}
+}
+
+func testFailReadWithoutRoutingKey(t *testing.T) {
+	r := NewReader(strings.NewReader(`{"body":{"id":1}}`))
+	if _, _, err := r.Read(); err == nil || !strings.Contains(err.Error(), ErrMissingRoutingKey.Error()) {
+		t.Fatalf("expected to have ErrMissingRoutingKey: %v", err)
+	}
+}
+
+func testRecordSettlements(t *testing.T) {
+	ac := NewAcknowledger()
+	ac.Ack(1, false)
+	ac.Nack(2, false, true)
+	ac.Reject(3, false)
+
+	expected := map[uint64]string{1: Acked, 2: Requeued, 3: Rejected, 4: Unsettled}
+	for tag, s := range expected {
+		if ac.Settlement(tag) != s {
+			t.Fatalf("unexpected settlement of %d: %s", tag, ac.Settlement(tag))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package stats
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "srv_consumer"
+
+type (
+	// Prometheus collects consumer metrics and exposes them in the Prometheus text format.
+	Prometheus struct {
+		registry     *prometheus.Registry
+		duration     *prometheus.HistogramVec
+		size         *prometheus.HistogramVec
+		processed    *prometheus.CounterVec
+		failed       *prometheus.CounterVec
+		acked        *prometheus.CounterVec
+		nacked       *prometheus.CounterVec
+		deadLettered *prometheus.CounterVec
+		redelivered  *prometheus.CounterVec
+		inFlight     *prometheus.GaugeVec
+	}
+)
+
+// NewPrometheus returns a new Prometheus collector with its own registry.
+func NewPrometheus() *Prometheus {
+	labels := []string{"routing_key", "handler"}
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "processing_duration_seconds",
+			Help:      "Time spent handling a message.",
+			Buckets:   prometheus.DefBuckets,
+		}, append(labels, "outcome")),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "message_size_bytes",
+			Help:      "Size of the handled message payloads.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, labels),
+		processed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_processed_total",
+			Help:      "Number of messages handled.",
+		}, append(labels, "outcome")),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_failed_total",
+			Help:      "Number of messages which handling failed.",
+		}, labels),
+		acked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_acked_total",
+			Help:      "Number of messages acknowledged.",
+		}, labels),
+		nacked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_nacked_total",
+			Help:      "Number of messages negatively acknowledged and requeued.",
+		}, labels),
+		deadLettered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_dead_lettered_total",
+			Help:      "Number of messages negatively acknowledged without requeue.",
+		}, labels),
+		redelivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_redelivered_total",
+			Help:      "Number of messages received as redeliveries.",
+		}, labels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "messages_in_flight",
+			Help:      "Number of messages being handled.",
+		}, labels),
+	}
+
+	p.registry.MustRegister(
+		p.duration,
+		p.size,
+		p.processed,
+		p.failed,
+		p.acked,
+		p.nacked,
+		p.deadLettered,
+		p.redelivered,
+		p.inFlight,
+	)
+
+	return p
+}
+
+// Handler returns the http handler serving the /metrics endpoint.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// Start starts the timing metric.
+func (p *Prometheus) Start(key, handler string) time.Time {
+	p.inFlight.WithLabelValues(key, handler).Inc()
+	return time.Now()
+}
+
+// Track tracks the handled message which timing started at t.
+func (p *Prometheus) Track(t time.Time, e Event) {
+	labels := prometheus.Labels{"routing_key": e.RoutingKey, "handler": e.Handler}
+	outcome := prometheus.Labels{"routing_key": e.RoutingKey, "handler": e.Handler, "outcome": string(e.Outcome)}
+
+	p.inFlight.With(labels).Dec()
+	p.processed.With(outcome).Inc()
+	p.duration.With(outcome).Observe(time.Since(t).Seconds())
+	p.size.With(labels).Observe(float64(e.Size))
+	if e.Redelivered {
+		p.redelivered.With(labels).Inc()
+	}
+	if e.Outcome != Success && e.Outcome != Parked {
+		p.failed.With(labels).Inc()
+	}
+
+	switch e.Outcome {
+	case Success, Dropped:
+		p.acked.With(labels).Inc()
+	case Retried, TimedOut:
+		p.nacked.With(labels).Inc()
+	case DeadLettered:
+		p.deadLettered.With(labels).Inc()
+	}
+}
@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus()
+	track := func(outcome Outcome, redelivered bool) {
+		p.Track(p.Start("user.created", "handler.UserCreated"), Event{
+			RoutingKey:  "user.created",
+			Handler:     "handler.UserCreated",
+			Outcome:     outcome,
+			Size:        128,
+			Redelivered: redelivered,
+		})
+	}
+	track(Success, false)
+	track(Retried, true)
+	track(DeadLettered, false)
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	expected := []string{
+		`srv_consumer_messages_processed_total{handler="handler.UserCreated",outcome="success",routing_key="user.created"} 1`,
+		`srv_consumer_messages_processed_total{handler="handler.UserCreated",outcome="retried",routing_key="user.created"} 1`,
+		`srv_consumer_messages_failed_total{handler="handler.UserCreated",routing_key="user.created"} 2`,
+		`srv_consumer_messages_acked_total{handler="handler.UserCreated",routing_key="user.created"} 1`,
+		`srv_consumer_messages_nacked_total{handler="handler.UserCreated",routing_key="user.created"} 1`,
+		`srv_consumer_messages_dead_lettered_total{handler="handler.UserCreated",routing_key="user.created"} 1`,
+		`srv_consumer_messages_redelivered_total{handler="handler.UserCreated",routing_key="user.created"} 1`,
+		`srv_consumer_messages_in_flight{handler="handler.UserCreated",routing_key="user.created"} 0`,
+		`srv_consumer_processing_duration_seconds_count{handler="handler.UserCreated",outcome="success",routing_key="user.created"} 1`,
+		`srv_consumer_message_size_bytes_count{handler="handler.UserCreated",routing_key="user.created"} 3`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(string(body), e) {
+			t.Fatalf("expected metrics to contain %s, got:\n%s", e, body)
+		}
+	}
+}
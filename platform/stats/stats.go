@@ -5,12 +5,52 @@ import (
 	"time"
 )
 
-type Client struct{}
+const (
+	// Success is a message handled successfully.
+	Success Outcome = "success"
+	// Dropped is a message which handling failed and was acked, it won't be retried.
+	Dropped Outcome = "dropped"
+	// Retried is a message which handling failed and was requeued.
+	Retried Outcome = "retried"
+	// DeadLettered is a message which handling failed and was rejected without requeue.
+	DeadLettered Outcome = "dead_lettered"
+	// Failed is a message which handling failed and was left unacknowledged.
+	Failed Outcome = "failed"
+	// Parked is a message left unacknowledged to be handled later.
+	Parked Outcome = "parked"
+	// TimedOut is a message which handling exceeded its deadline and was requeued.
+	TimedOut Outcome = "timeout"
+)
+
+type (
+	// Outcome is the class of a handled message outcome.
+	Outcome string
+
+	// Event describes a handled message.
+	Event struct {
+		// RoutingKey is the routing key the message was consumed from.
+		RoutingKey string
+		// Handler is the name of the message handler.
+		Handler string
+		// Outcome is the class of the handling outcome.
+		Outcome Outcome
+		// Size is the message payload size in bytes.
+		Size int
+		// Redelivered tells whether the message was delivered before.
+		Redelivered bool
+	}
+
+	// Client logs the stats of handled messages.
+	Client struct{}
+)
 
-func (c *Client) Start() time.Time {
+// Start starts the timing metric.
+func (c *Client) Start(key, handler string) time.Time {
 	return time.Now()
 }
 
-func (c *Client) Track(t time.Time, err bool) {
-	log.Print("sending stats timing metric")
+// Track tracks the handled message which timing started at t.
+func (c *Client) Track(t time.Time, e Event) {
+	log.Printf("handled message routing_key=%s handler=%s outcome=%s size=%d redelivered=%t duration=%s",
+		e.RoutingKey, e.Handler, e.Outcome, e.Size, e.Redelivered, time.Since(t))
 }
@@ -0,0 +1,200 @@
+package stats
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPacketSize keeps packets within a typical ethernet MTU.
+const maxPacketSize = 1432
+
+type (
+	// StatsD sends metrics over UDP in the StatsD format, with DogStatsD tags.
+	// Metrics are batched in packets which are sent when full or every flush interval.
+	StatsD struct {
+		conn     net.Conn
+		prefix   string
+		rate     float64
+		mu       sync.Mutex
+		buf      []byte
+		inFlight map[string]int64
+		done     chan struct{}
+		stopped  chan struct{}
+	}
+)
+
+// NewStatsD returns a StatsD client sending to addr every interval. Counters and
+// timings are sampled at rate, in the range (0, 1].
+func NewStatsD(addr, prefix string, rate float64, interval time.Duration) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+
+	s := &StatsD{
+		conn:     conn,
+		prefix:   prefix,
+		rate:     rate,
+		buf:      make([]byte, 0, maxPacketSize),
+		inFlight: make(map[string]int64),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.loop(interval)
+
+	return s, nil
+}
+
+// Start starts the timing metric.
+func (s *StatsD) Start(key, handler string) time.Time {
+	base := tags(key, handler)
+	s.gauge("messages.in_flight", s.addInFlight(base, 1), base)
+	return time.Now()
+}
+
+// Track tracks the handled message which timing started at t.
+func (s *StatsD) Track(t time.Time, e Event) {
+	base := tags(e.RoutingKey, e.Handler)
+	withOutcome := base + ",outcome:" + string(e.Outcome)
+
+	s.gauge("messages.in_flight", s.addInFlight(base, -1), base)
+	s.count("messages.processed", withOutcome)
+	s.timing("processing_duration", time.Since(t), withOutcome)
+	s.histogram("message_size", e.Size, base)
+	if e.Redelivered {
+		s.count("messages.redelivered", base)
+	}
+	if e.Outcome != Success && e.Outcome != Parked {
+		s.count("messages.failed", base)
+	}
+
+	switch e.Outcome {
+	case Success, Dropped:
+		s.count("messages.acked", base)
+	case Retried, TimedOut:
+		s.count("messages.nacked", base)
+	case DeadLettered:
+		s.count("messages.dead_lettered", base)
+	}
+}
+
+// Flush sends the buffered metrics.
+func (s *StatsD) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+// Close flushes the buffered metrics and closes the connection.
+func (s *StatsD) Close() error {
+	close(s.done)
+	<-s.stopped
+
+	if err := s.Flush(); err != nil {
+		s.conn.Close()
+		return err
+	}
+
+	return s.conn.Close()
+}
+
+func (s *StatsD) loop(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *StatsD) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	_, err := s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+	return err
+}
+
+func (s *StatsD) count(name, tags string) {
+	if !s.sampled() {
+		return
+	}
+	s.send(name, "1", "c", tags, s.rate)
+}
+
+func (s *StatsD) timing(name string, d time.Duration, tags string) {
+	if !s.sampled() {
+		return
+	}
+	s.send(name, strconv.FormatFloat(d.Seconds()*1000, 'f', -1, 64), "ms", tags, s.rate)
+}
+
+func (s *StatsD) histogram(name string, v int, tags string) {
+	if !s.sampled() {
+		return
+	}
+	s.send(name, strconv.Itoa(v), "h", tags, s.rate)
+}
+
+func (s *StatsD) gauge(name string, v int64, tags string) {
+	s.send(name, strconv.FormatInt(v, 10), "g", tags, 1)
+}
+
+func (s *StatsD) addInFlight(tags string, delta int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight[tags] += delta
+	return s.inFlight[tags]
+}
+
+func (s *StatsD) sampled() bool {
+	return s.rate >= 1 || rand.Float64() < s.rate
+}
+
+// send buffers a metric line, e.g. prefix.name:1|c|@0.5|#tag:value.
+func (s *StatsD) send(name, value, kind, tags string, rate float64) {
+	line := s.prefix + name + ":" + value + "|" + kind
+	if rate < 1 {
+		line += "|@" + strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+	if tags != "" {
+		line += "|#" + tags
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf) > 0 && len(s.buf)+len(line)+1 > maxPacketSize {
+		s.flush()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+func tags(key, handler string) string {
+	return "routing_key:" + key + ",handler:" + handler
+}
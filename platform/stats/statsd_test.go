@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsD(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, net.PacketConn)
+	}{
+		{
+			"send batched metrics on close",
+			testSendBatchedMetrics,
+		},
+		{
+			"split batches larger than a packet",
+			testSplitLargeBatches,
+		},
+		{
+			"send sample rate",
+			testSendSampleRate,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to listen udp: %v", err)
+			}
+			defer conn.Close()
+			test.function(t, conn)
+		})
+	}
+}
+
+func testSendBatchedMetrics(t *testing.T, conn net.PacketConn) {
+	s, err := NewStatsD(conn.LocalAddr().String(), "srv", 1, time.Hour)
+	if err != nil {
+		t.Fatalf("expected to create statsd client: %v", err)
+	}
+
+	s.Track(s.Start("user.created", "handler.UserCreated"), Event{
+		RoutingKey:  "user.created",
+		Handler:     "handler.UserCreated",
+		Outcome:     DeadLettered,
+		Size:        128,
+		Redelivered: true,
+	})
+	if err := s.Close(); err != nil {
+		t.Fatalf("expected to close statsd client: %v", err)
+	}
+
+	packets := readPackets(t, conn, 1)
+	lines := strings.Split(packets[0], "\n")
+	tags := "|#routing_key:user.created,handler:handler.UserCreated"
+	expected := []string{
+		"srv.messages.in_flight:1|g" + tags,
+		"srv.messages.in_flight:0|g" + tags,
+		"srv.messages.processed:1|c" + tags + ",outcome:dead_lettered",
+		"srv.processing_duration:",
+		"srv.message_size:128|h" + tags,
+		"srv.messages.redelivered:1|c" + tags,
+		"srv.messages.failed:1|c" + tags,
+		"srv.messages.dead_lettered:1|c" + tags,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("unexpected lines: %v", lines)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(lines[i], e) {
+			t.Fatalf("unexpected line %d: %s", i, lines[i])
+		}
+	}
+	if !strings.HasSuffix(lines[3], "|ms"+tags+",outcome:dead_lettered") {
+		t.Fatalf("unexpected timing line: %s", lines[3])
+	}
+}
+
+func testSplitLargeBatches(t *testing.T, conn net.PacketConn) {
+	s, err := NewStatsD(conn.LocalAddr().String(), "srv", 1, time.Hour)
+	if err != nil {
+		t.Fatalf("expected to create statsd client: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.count("messages.acked", "")
+	}
+	s.Close()
+
+	packets := readPackets(t, conn, 2)
+	for _, p := range packets {
+		if len(p) > maxPacketSize {
+			t.Fatalf("unexpected packet size: %d", len(p))
+		}
+	}
+	if n := strings.Count(packets[0]+"\n"+packets[1], "messages.acked"); n != 100 {
+		t.Fatalf("unexpected metrics count: %d", n)
+	}
+}
+
+func testSendSampleRate(t *testing.T, conn net.PacketConn) {
+	s, err := NewStatsD(conn.LocalAddr().String(), "srv.", 0.999999, time.Hour)
+	if err != nil {
+		t.Fatalf("expected to create statsd client: %v", err)
+	}
+
+	s.count("messages.acked", "")
+	s.Close()
+
+	packets := readPackets(t, conn, 1)
+	if packets[0] != "srv.messages.acked:1|c|@0.999999" {
+		t.Fatalf("unexpected packet: %s", packets[0])
+	}
+}
+
+func readPackets(t *testing.T, conn net.PacketConn, n int) []string {
+	packets := make([]string, 0, n)
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < n; i++ {
+		size, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("failed to read packet: %v", err)
+		}
+		packets = append(packets, string(buf[:size]))
+	}
+
+	return packets
+}
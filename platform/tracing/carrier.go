@@ -0,0 +1,39 @@
+package tracing
+
+import "go.opentelemetry.io/otel/propagation"
+
+var (
+	// make sure HeaderCarrier satisfies propagation.TextMapCarrier interface.
+	_ propagation.TextMapCarrier = HeaderCarrier(nil)
+)
+
+// HeaderCarrier adapts amqp message headers to propagation.TextMapCarrier,
+// so traceparent and tracestate can be read from and written to deliveries.
+type HeaderCarrier map[string]interface{}
+
+// Get returns the value associated with the passed key.
+func (c HeaderCarrier) Get(key string) string {
+	switch v := c[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+// Set stores the key-value pair.
+func (c HeaderCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the keys stored in this carrier.
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
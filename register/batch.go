@@ -0,0 +1,301 @@
+package register
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrInvalidBatch is returned when batching less than one message or waiting a negative duration.
+	ErrInvalidBatch = errors.New("invalid batch")
+	// ErrBatchUnsupported is returned when batching the messages of a handler which is not a message.BatchHandler.
+	ErrBatchUnsupported = errors.New("handler does not support batches")
+	// ErrShardedBatch is returned when combining batches with more than one shard worker.
+	ErrShardedBatch = errors.New("batches can't be sharded")
+
+	errUnhandled = errors.New("message not handled by batch handler")
+)
+
+type (
+	// deferred records the acks of a message handled in a batch, which are sent at once for the whole batch.
+	// The acks of a parked message are sent on their own, as it is settled after its batch.
+	deferred struct {
+		message.Acknowledger
+		mu     sync.Mutex
+		acked  bool
+		parked bool
+	}
+
+	// pending is a delivery of a batch along with its message and how it was settled.
+	pending struct {
+		delivery amqp.Delivery
+		msg      *message.Message
+		ack      *deferred
+		settled  *settlement
+		timing   time.Time
+		event    stats.Event
+		err      error
+	}
+)
+
+func (d *deferred) Ack(multiple bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.parked {
+		return d.Acknowledger.Ack(false)
+	}
+	d.acked = true
+	return nil
+}
+
+// isAcked reports whether the message was acked within its batch.
+func (d *deferred) isAcked() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.acked
+}
+
+// park sends the later acks of the message on their own, unless it was already acked within its batch,
+// e.g. when released while parking it, in which case it returns false.
+func (d *deferred) park() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.acked {
+		return false
+	}
+	d.parked = true
+	return true
+}
+
+// Batch handles the messages in batches of up to size messages, waiting at most wait for a batch
+// to fill up. The acked messages of a batch are acknowledged at once with a multiple ack, so the
+// consumer must not share its channel with other registers, or one by one when the batch has parked
+// messages, which the multiple ack would acknowledge too. Batches are handled one at a time,
+// so it can't be combined with Shard on more than one worker. The timeout bounds each batch as a whole.
+// It must be called before Run.
+func (r *Register) Batch(size int, wait time.Duration) error {
+	if size < 1 || wait < 0 {
+		return ErrInvalidBatch
+	}
+	if _, ok := r.handler.(message.BatchHandler); !ok {
+		return ErrBatchUnsupported
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.workers > 1 {
+		return ErrShardedBatch
+	}
+
+	r.batchSize = size
+	r.batchWait = wait
+	return nil
+}
+
+// batching returns the size of the batches and how long to wait for them to fill up.
+func (r *Register) batching() (int, time.Duration) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.batchSize, r.batchWait
+}
+
+// batches collects the messages sent to the returned dispatch function in batches handled in order,
+// until the returned stop function is called, which waits for the collected messages to be handled.
+func (r *Register) batches(ctx context.Context, size int, wait time.Duration) (dispatch func(amqp.Delivery), stop func()) {
+	queue := make(chan amqp.Delivery, size)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range queue {
+			batch := []amqp.Delivery{m}
+			timer := time.NewTimer(wait)
+		collect:
+			for len(batch) < size {
+				select {
+				case m, ok := <-queue:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, m)
+				case <-timer.C:
+					break collect
+				}
+			}
+			timer.Stop()
+
+			r.handleBatch(ctx, batch)
+			for range batch {
+				r.end()
+			}
+			r.beat()
+		}
+	}()
+
+	dispatch = func(m amqp.Delivery) {
+		// counted in flight while collected, so Drain waits for it.
+		r.begin()
+		select {
+		case queue <- m:
+		case <-ctx.Done():
+			// left unacknowledged, it is delivered again.
+			r.end()
+		}
+	}
+	stop = func() {
+		close(queue)
+		<-done
+	}
+
+	return dispatch, stop
+}
+
+// handleBatch handles the deliveries with the batch handler, nacking the failed ones one by one
+// before acking the others with a single multiple ack. Parked messages are left unacknowledged.
+func (r *Register) handleBatch(ctx context.Context, deliveries []amqp.Delivery) {
+	ctx, span := tracer().Start(ctx, r.key+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation", "process"),
+			attribute.String("messaging.destination.name", r.exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", r.key),
+			attribute.Int("messaging.batch.message_count", len(deliveries)),
+		),
+	)
+	defer span.End()
+
+	logger := r.logger.With(
+		"routing_key", r.key,
+		"exchange", r.exchange,
+		"handler", r.name,
+		"batch_size", len(deliveries),
+	)
+	ctx = logging.WithContext(ctx, logger)
+
+	var (
+		items = make([]*pending, 0, len(deliveries))
+		msgs  = make([]*message.Message, 0, len(deliveries))
+	)
+	for _, d := range deliveries {
+		p := &pending{
+			delivery: d,
+			timing:   r.stats.Start(r.key, r.name),
+			event: stats.Event{
+				RoutingKey:  r.key,
+				Handler:     r.name,
+				Size:        len(d.Body),
+				Redelivered: d.Redelivered,
+			},
+		}
+		msg, err := message.FromDelivery(d)
+		if err != nil {
+			logger.Warn("failed to decode message", "message_id", d.MessageId, "error", err)
+			p.ack = &deferred{Acknowledger: d}
+			p.ack.Ack(false)
+			p.event.Outcome = stats.Dropped
+			items = append(items, p)
+			continue
+		}
+
+		p.ack = &deferred{Acknowledger: msg.Acknowledger}
+		p.settled = &settlement{Acknowledger: p.ack}
+		msg.Acknowledger = p.settled
+		p.msg = msg
+		items = append(items, p)
+		msgs = append(msgs, msg)
+	}
+
+	var (
+		errs      []error
+		expired   bool
+		abandoned <-chan struct{}
+	)
+	if len(msgs) > 0 {
+		errs, expired, abandoned = withTimeout(ctx, r.handleTimeout(), r.grace, func(ctx context.Context) []error {
+			return r.handler.(message.BatchHandler).HandleBatch(ctx, msgs)
+		})
+	}
+	if abandoned != nil {
+		logger.Error("batch handler still running past its deadline, abandoning it")
+		r.abandon(abandoned)
+	}
+	if expired {
+		logger.Warn("batch handling timed out")
+	}
+
+	var (
+		acked  []*pending
+		parked bool
+	)
+	i := 0
+	for _, p := range items {
+		if p.msg == nil {
+			acked = append(acked, p)
+			continue
+		}
+
+		p.err = errUnhandled
+		if i < len(errs) {
+			p.err = errs[i]
+		}
+		i++
+
+		if p.err == message.ErrParked && p.ack.park() {
+			// left unacknowledged, it is settled once released or expired.
+			p.event.Outcome = stats.Parked
+			parked = true
+			continue
+		}
+		// requeues the unsettled messages, the multiple ack would acknowledge them otherwise.
+		if err := p.settled.expire(); err != nil {
+			logger.Error("failed to nack message", "message_id", p.msg.ID, "error", err)
+		}
+		if !p.ack.isAcked() && (expired || (p.err != nil && timedOut(p.err))) {
+			p.err = ErrTimeout
+		}
+		p.event.Outcome = p.settled.outcome(p.err)
+		if p.ack.isAcked() {
+			acked = append(acked, p)
+		}
+	}
+
+	switch {
+	case len(acked) == 0:
+	case parked:
+		// the multiple ack would acknowledge the parked messages delivered before the last acked one.
+		for _, p := range acked {
+			if err := p.delivery.Ack(false); err != nil {
+				logger.Error("failed to ack message", "message_id", p.delivery.MessageId, "error", err)
+				p.event.Outcome = stats.Failed
+			}
+		}
+	default:
+		if err := acked[len(acked)-1].delivery.Ack(true); err != nil {
+			logger.Error("failed to ack batch", "error", err)
+			for _, p := range acked {
+				p.event.Outcome = stats.Failed
+			}
+		}
+	}
+
+	outcomes := make(map[stats.Outcome]int)
+	for _, p := range items {
+		r.stats.Track(p.timing, p.event)
+		outcomes[p.event.Outcome]++
+	}
+	logger.Debug("batch handled", "outcomes", outcomes)
+}
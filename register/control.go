@@ -0,0 +1,251 @@
+package register
+
+import (
+	"context"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// drainInterval is how often Drain checks whether in-flight messages were handled.
+const drainInterval = 50 * time.Millisecond
+
+type (
+	// Info describes the state of a register.
+	Info struct {
+		RoutingKey    string    `json:"routing_key"`
+		Exchange      string    `json:"exchange"`
+		Queue         string    `json:"queue,omitempty"`
+		Handler       string    `json:"handler"`
+		Consuming     bool      `json:"consuming"`
+		Paused        bool      `json:"paused"`
+		Prefetch      int       `json:"prefetch"`
+		InFlight      int       `json:"in_flight"`
+		Workers       int       `json:"workers"`
+		BatchSize     int       `json:"batch_size,omitempty"`
+		Timeout       string    `json:"timeout,omitempty"`
+		LastProcessed time.Time `json:"last_processed"`
+	}
+
+	// queueNamer is implemented by consumers which name the queue bound to a routing key.
+	queueNamer interface {
+		Queue(routingKey string) string
+	}
+)
+
+// Info returns the current state of the register.
+func (r *Register) Info() Info {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	info := Info{
+		RoutingKey:    r.key,
+		Exchange:      r.exchange,
+		Handler:       r.name,
+		Consuming:     r.consuming,
+		Paused:        r.paused,
+		Prefetch:      r.prefetch,
+		InFlight:      r.inFlight,
+		Workers:       r.workers,
+		BatchSize:     r.batchSize,
+		LastProcessed: r.lastProcessed,
+	}
+	if r.timeout > 0 {
+		info.Timeout = r.timeout.String()
+	}
+	if q, ok := r.consumer.(queueNamer); ok {
+		info.Queue = q.Queue(r.key)
+	}
+
+	return info
+}
+
+// Pause stops consuming messages, the ones already delivered are still handled.
+func (r *Register) Pause() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.paused {
+		return nil
+	}
+	if err := r.consumer.Cancel(r.key); err != nil {
+		return err
+	}
+
+	r.paused = true
+	return nil
+}
+
+// Resume starts consuming messages again after Pause.
+func (r *Register) Resume() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.paused {
+		return nil
+	}
+	if err := r.resubscribe(); err != nil {
+		return err
+	}
+
+	r.paused = false
+	return nil
+}
+
+// SetPrefetch changes how many messages are delivered ahead of being acknowledged.
+// Unless paused, the register consumes again so the new prefetch applies. As the amqp prefetch
+// applies to a whole channel, the consumer must not share its channel with other registers.
+func (r *Register) SetPrefetch(prefetch int) error {
+	if prefetch < 0 {
+		return ErrInvalidPrefetch
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.consumer.Qos(prefetch); err != nil {
+		return err
+	}
+	r.prefetch = prefetch
+
+	if r.paused {
+		return nil
+	}
+	if err := r.consumer.Cancel(r.key); err != nil {
+		return err
+	}
+
+	return r.resubscribe()
+}
+
+// Drain pauses the register and waits until the messages already delivered are handled.
+func (r *Register) Drain(ctx context.Context) error {
+	if err := r.Pause(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for {
+		if r.drained() {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Check returns ErrNotConsuming unless the register is consuming messages or was paused,
+// and ErrStuck while handlers abandoned past their deadline are still running.
+func (r *Register) Check(ctx context.Context) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if !r.consuming && !r.paused {
+		return ErrNotConsuming
+	}
+	if r.abandoned > 0 {
+		return ErrStuck
+	}
+
+	return nil
+}
+
+// Progress returns the last time the register loop made progress.
+func (r *Register) Progress() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.progress
+}
+
+// resubscribe consumes the routing key again and notifies the Run loop, r.mu must be held.
+func (r *Register) resubscribe() error {
+	msgchan, err := r.consumer.Consume(r.key, r.exchange)
+	if err != nil {
+		return err
+	}
+
+	r.msgchan = msgchan
+	select {
+	case r.resumed <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// sharding returns the number of workers and the key messages are sharded on.
+func (r *Register) sharding() (int, ShardKey) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.workers, r.shardKey
+}
+
+func (r *Register) deliveries() <-chan amqp.Delivery {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.msgchan
+}
+
+// closed returns the channel to read from once msgchan was closed,
+// which is nil unless the register consumed again in the meantime.
+func (r *Register) closed(msgchan <-chan amqp.Delivery) <-chan amqp.Delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.msgchan != msgchan {
+		return r.msgchan
+	}
+
+	if !r.paused {
+		r.logger.Error("messages channel closed", "routing_key", r.key)
+	}
+	r.msgchan = nil
+	r.consuming = false
+	return nil
+}
+
+func (r *Register) drained() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return !r.consuming && r.inFlight == 0
+}
+
+func (r *Register) setConsuming(consuming bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.consuming = consuming
+	r.progress = time.Now()
+}
+
+func (r *Register) beat() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.progress = time.Now()
+}
+
+func (r *Register) begin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inFlight++
+}
+
+func (r *Register) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inFlight--
+	r.lastProcessed = time.Now()
+}
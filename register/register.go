@@ -2,52 +2,242 @@ package register
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/rafaeljesus/srv-consumer/platform/logging"
 	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
+	"github.com/rafaeljesus/srv-consumer/platform/tracing"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// heartbeat is how often an idle register reports progress.
+const heartbeat = 5 * time.Second
+
+// tracerName is the instrumentation name of the register spans.
+const tracerName = "github.com/rafaeljesus/srv-consumer/register"
+
+var (
+	// ErrNotConsuming is returned by Check when the register is not consuming messages.
+	ErrNotConsuming = errors.New("register is not consuming")
+	// ErrInvalidPrefetch is returned when setting a negative prefetch.
+	ErrInvalidPrefetch = errors.New("invalid prefetch")
 )
 
 type (
 	// Stats expose methods for collecting metrics.
 	Stats interface {
-		// Start starts the timing metric.
-		Start() time.Time
-		// Track tracks operations for the given time.
-		Track(t time.Time, err bool)
+		// Start starts the timing metric of a message received for the given routing key and handler.
+		Start(key, handler string) time.Time
+		// Track tracks the handled message which timing started at t.
+		Track(t time.Time, e stats.Event)
 	}
 
 	// Register holds the fields for receiving incoming amqp messages.
 	Register struct {
-		msgchan <-chan amqp.Delivery
-		handler message.Handler
-		stats   Stats
+		key      string
+		exchange string
+		name     string
+		consumer message.Consumer
+		handler  message.Handler
+		stats    Stats
+		logger   *slog.Logger
+		resumed  chan struct{}
+
+		mu            sync.RWMutex
+		workers       int
+		shardKey      ShardKey
+		batchSize     int
+		batchWait     time.Duration
+		timeout       time.Duration
+		msgchan       <-chan amqp.Delivery
+		consuming     bool
+		paused        bool
+		prefetch      int
+		inFlight      int
+		abandoned     int
+		grace         time.Duration
+		progress      time.Time
+		lastProcessed time.Time
 	}
 )
 
 // New returns a configured register.
-func New(key, ex string, c message.Consumer, h message.Handler, s Stats) (*Register, error) {
+func New(key, ex string, c message.Consumer, h message.Handler, s Stats, l *slog.Logger) (*Register, error) {
 	msgchan, err := c.Consume(key, ex)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Register{msgchan, h, s}, nil
+	return &Register{
+		key:      key,
+		exchange: ex,
+		name:     handlerName(h),
+		consumer: c,
+		handler:  h,
+		stats:    s,
+		logger:   l,
+		resumed:  make(chan struct{}, 1),
+		msgchan:  msgchan,
+		workers:  1,
+		grace:    abandonGrace,
+	}, nil
 }
 
 // Run starts reading from amqp messages channel.
 func (r *Register) Run(ctx context.Context) error {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	msgchan := r.deliveries()
+	r.setConsuming(msgchan != nil)
+	defer r.setConsuming(false)
+
+	dispatch := func(m amqp.Delivery) {
+		r.Handle(ctx, m)
+		r.beat()
+	}
+	if size, wait := r.batching(); size > 0 {
+		var stop func()
+		dispatch, stop = r.batches(ctx, size, wait)
+		defer stop()
+	} else if workers, key := r.sharding(); workers > 1 {
+		var stop func()
+		dispatch, stop = r.shards(ctx, workers, key)
+		defer stop()
+	}
+
 	for {
 		select {
-		case m, ok := <-r.msgchan:
-			if ok {
-				timing := r.stats.Start()
-				msg := message.New(m, m.Body)
-				err := r.handler.Handle(ctx, msg)
-				r.stats.Track(timing, err == nil)
+		case m, ok := <-msgchan:
+			if !ok {
+				msgchan = r.closed(msgchan)
+				continue
 			}
+			dispatch(m)
+		case <-r.resumed:
+			if msgchan == nil {
+				msgchan = r.deliveries()
+				r.setConsuming(msgchan != nil)
+			}
+		case <-ticker.C:
+			r.beat()
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
+
+// Handle handles a single delivery as Run does for the consumed ones, returning the tracked event.
+func (r *Register) Handle(ctx context.Context, m amqp.Delivery) stats.Event {
+	r.begin()
+	defer r.end()
+
+	return r.handle(ctx, m)
+}
+
+func (r *Register) handle(ctx context.Context, m amqp.Delivery) stats.Event {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, tracing.HeaderCarrier(m.Headers))
+	ctx, span := tracer().Start(ctx, r.key+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation", "process"),
+			attribute.String("messaging.destination.name", r.exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", r.key),
+			attribute.String("messaging.message.id", m.MessageId),
+			attribute.String("messaging.message.conversation_id", m.CorrelationId),
+			attribute.Int("messaging.message.body.size", len(m.Body)),
+		),
+	)
+	defer span.End()
+
+	logger := r.logger.With(
+		"routing_key", r.key,
+		"exchange", r.exchange,
+		"handler", r.name,
+		"message_id", m.MessageId,
+		"correlation_id", m.CorrelationId,
+		"delivery_tag", m.DeliveryTag,
+		"redelivered", m.Redelivered,
+	)
+	if sc := span.SpanContext(); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+
+	timing := r.stats.Start(r.key, r.name)
+	event := stats.Event{
+		RoutingKey:  r.key,
+		Handler:     r.name,
+		Size:        len(m.Body),
+		Redelivered: m.Redelivered,
+	}
+
+	msg, err := message.FromDelivery(m)
+	if err != nil {
+		logger.Warn("failed to decode message", "error", err)
+		if err := m.Ack(false); err != nil {
+			logger.Error("failed to ack message", "error", err)
+		}
+		event.Outcome = stats.Dropped
+		r.stats.Track(timing, event)
+		endSpan(span, event.Outcome, err)
+		return event
+	}
+
+	if msg.ID != m.MessageId {
+		logger = logger.With("event_id", msg.ID)
+	}
+	ctx = logging.WithContext(ctx, logger)
+
+	s := &settlement{Acknowledger: msg.Acknowledger}
+	msg.Acknowledger = s
+	err, expired, abandoned := withTimeout(ctx, r.handleTimeout(), r.grace, func(ctx context.Context) error {
+		return r.handler.Handle(ctx, msg)
+	})
+	if abandoned != nil {
+		logger.Error("message handler still running past its deadline, abandoning it")
+		r.abandon(abandoned)
+		err = ErrTimeout
+	}
+	if err != nil && (expired || timedOut(err)) {
+		logger.Warn("message handling timed out", "error", err)
+		if err := s.expire(); err != nil {
+			logger.Error("failed to nack message", "error", err)
+		}
+		err = ErrTimeout
+	}
+	event.Outcome = s.outcome(err)
+	r.stats.Track(timing, event)
+	logger.Debug("message handled", "outcome", event.Outcome)
+	endSpan(span, event.Outcome, err)
+	return event
+}
+
+// tracer returns the tracer of the global provider, looked up on every message so it follows the provider
+// currently set rather than the one set first.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+func endSpan(span trace.Span, outcome stats.Outcome, err error) {
+	span.SetAttributes(attribute.String("srv_consumer.outcome", string(outcome)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// handlerName returns the handler type name, e.g. handler.UserCreated.
+func handlerName(h message.Handler) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", h), "*")
+}
@@ -3,16 +3,28 @@ package register
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/rafaeljesus/srv-consumer/mock"
 	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/message/inmem"
+	srvstats "github.com/rafaeljesus/srv-consumer/platform/stats"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	amqpError = errors.New("amqp error")
+	logger    = slog.New(slog.NewTextHandler(ioutil.Discard, nil))
 )
 
 func TestListener(t *testing.T) {
@@ -36,6 +48,62 @@ func TestListener(t *testing.T) {
 			"handle context done",
 			testHandlerContextDone,
 		},
+		{
+			"track dead lettered outcome",
+			testTrackDeadLetteredOutcome,
+		},
+		{
+			"continue trace from message headers",
+			testContinueTrace,
+		},
+		{
+			"report consuming state",
+			testReportConsumingState,
+		},
+		{
+			"pause and resume consuming",
+			testPauseAndResume,
+		},
+		{
+			"drain in flight messages",
+			testDrain,
+		},
+		{
+			"set prefetch",
+			testSetPrefetch,
+		},
+		{
+			"handle sharded messages in order per key",
+			testHandleShardedMessagesInOrder,
+		},
+		{
+			"fail to shard on no worker",
+			testFailToShardOnNoWorker,
+		},
+		{
+			"read shard key",
+			testReadShardKey,
+		},
+		{
+			"ack batch at once and nack failed messages one by one",
+			testHandleBatch,
+		},
+		{
+			"ack batch with parked messages one by one",
+			testAckBatchWithParkedMessages,
+		},
+		{
+			"fail to batch unsupported handler",
+			testFailToBatchUnsupportedHandler,
+		},
+		{
+			"requeue message which handling timed out",
+			testRequeueTimedOutMessage,
+		},
+		{
+			"abandon handler ignoring its deadline",
+			testAbandonStuckHandler,
+		},
 	}
 
 	for _, test := range tests {
@@ -59,7 +127,7 @@ func testCreateNewListener(t *testing.T, consumer *mock.Consumer, handler *mock.
 		return make(<-chan amqp.Delivery), nil
 	}
 
-	if _, err := New("key", "ex", consumer, handler, stats); err != nil {
+	if _, err := New("key", "ex", consumer, handler, stats, logger); err != nil {
 		t.Fatalf("expected to create new listener: %v", err)
 	}
 	if !consumer.ConsumeInvoked {
@@ -69,7 +137,7 @@ func testCreateNewListener(t *testing.T, consumer *mock.Consumer, handler *mock.
 
 func testFailToCreateNewListener(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
 	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return nil, amqpError }
-	if _, err := New("key", "ex", consumer, handler, stats); err != amqpError {
+	if _, err := New("key", "ex", consumer, handler, stats, logger); err != amqpError {
 		t.Fatalf("expected to have amqpError: %v", err)
 	}
 	if !consumer.ConsumeInvoked {
@@ -94,16 +162,25 @@ func testRunListener(t *testing.T, consumer *mock.Consumer, handler *mock.Handle
 		}
 		return nil
 	}
-	stats.TrackFunc = func(tm time.Time, ok bool) {
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) {
 		if tm.IsZero() {
 			t.Fatal("unexpected time value")
 		}
-		if !ok {
-			t.Fatalf("unexpected ok: %t", ok)
+		if e.Outcome != srvstats.Success {
+			t.Fatalf("unexpected outcome: %s", e.Outcome)
+		}
+		if e.RoutingKey != "key" {
+			t.Fatalf("unexpected routing key: %s", e.RoutingKey)
+		}
+		if e.Handler != "mock.Handler" {
+			t.Fatalf("unexpected handler: %s", e.Handler)
+		}
+		if e.Size != 3 {
+			t.Fatalf("unexpected size: %d", e.Size)
 		}
 	}
 
-	l, err := New("key", "ex", consumer, handler, stats)
+	l, err := New("key", "ex", consumer, handler, stats, logger)
 	if err != nil {
 		t.Fatalf("expected to create new listener: %v", err)
 	}
@@ -133,9 +210,9 @@ func testRunListener(t *testing.T, consumer *mock.Consumer, handler *mock.Handle
 func testHandlerContextDone(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
 	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return make(chan amqp.Delivery), nil }
 	handler.HandleFunc = func(ctx context.Context, m *message.Message) error { return nil }
-	stats.TrackFunc = func(tm time.Time, ok bool) {}
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) {}
 
-	l, err := New("key", "ex", consumer, handler, stats)
+	l, err := New("key", "ex", consumer, handler, stats, logger)
 	if err != nil {
 		t.Fatalf("expected to create new listener: %v", err)
 	}
@@ -154,3 +231,608 @@ func testHandlerContextDone(t *testing.T, consumer *mock.Consumer, handler *mock
 		t.Fatal("expected stats.Track() to not be invoked")
 	}
 }
+
+func testTrackDeadLetteredOutcome(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	broker := inmem.NewBroker()
+	handler.HandleFunc = func(ctx context.Context, m *message.Message) error {
+		if err := m.Reject(false); err != nil {
+			t.Fatalf("expected to reject message: %v", err)
+		}
+		return amqpError
+	}
+	events := make(chan srvstats.Event, 1)
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) { events <- e }
+
+	l, err := New("key", "ex", broker, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go l.Run(ctx)
+	broker.Publish("ex", "key", amqp.Publishing{Body: []byte(`foo`)})
+
+	select {
+	case e := <-events:
+		if e.Outcome != srvstats.DeadLettered {
+			t.Fatalf("unexpected outcome: %s", e.Outcome)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected stats.Track() to be invoked")
+	}
+}
+
+func testContinueTrace(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	broker := inmem.NewBroker()
+	handler.HandleFunc = func(ctx context.Context, m *message.Message) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			t.Fatal("expected context to carry a span")
+		}
+		return m.Ack(false)
+	}
+	done := make(chan struct{})
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) { close(done) }
+
+	l, err := New("key", "ex", broker, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go l.Run(ctx)
+	broker.Publish("ex", "key", amqp.Publishing{
+		Headers: amqp.Table{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		Body:    []byte(`foo`),
+	})
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("expected stats.Track() to be invoked")
+	}
+
+	for i := 0; i < 10 && len(recorder.Ended()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("unexpected spans: %d", len(spans))
+	}
+	if spans[0].Name() != "key process" {
+		t.Fatalf("unexpected span name: %s", spans[0].Name())
+	}
+	if spans[0].SpanKind() != trace.SpanKindConsumer {
+		t.Fatalf("unexpected span kind: %s", spans[0].SpanKind())
+	}
+	if spans[0].Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("unexpected trace id: %s", spans[0].Parent().TraceID())
+	}
+	if spans[0].Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected parent span id: %s", spans[0].Parent().SpanID())
+	}
+}
+
+func testReportConsumingState(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	msgchan := make(chan amqp.Delivery)
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return msgchan, nil }
+
+	l, err := New("key", "ex", consumer, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.Check(context.Background()); err != ErrNotConsuming {
+		t.Fatalf("expected to have ErrNotConsuming: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go l.Run(ctx)
+	for i := 0; i < 10 && l.Check(ctx) != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := l.Check(ctx); err != nil {
+		t.Fatalf("expected register to be consuming: %v", err)
+	}
+	if l.Progress().IsZero() {
+		t.Fatal("expected register to report progress")
+	}
+
+	close(msgchan)
+	for i := 0; i < 10 && l.Check(ctx) == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := l.Check(ctx); err != ErrNotConsuming {
+		t.Fatalf("expected to have ErrNotConsuming: %v", err)
+	}
+}
+
+func testPauseAndResume(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	broker := inmem.NewBroker()
+	handler.HandleFunc = func(ctx context.Context, m *message.Message) error { return m.Ack(false) }
+	handled := make(chan srvstats.Event, 1)
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) { handled <- e }
+
+	l, err := New("key", "ex", broker, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go l.Run(ctx)
+	if err := l.Pause(); err != nil {
+		t.Fatalf("expected to pause: %v", err)
+	}
+	for i := 0; i < 10 && l.Info().Consuming; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info := l.Info(); info.Consuming || !info.Paused {
+		t.Fatalf("expected register to be paused: %+v", info)
+	}
+	if err := l.Check(ctx); err != nil {
+		t.Fatalf("expected paused register to be ready: %v", err)
+	}
+
+	broker.Publish("ex", "key", amqp.Publishing{Body: []byte(`foo`)})
+	select {
+	case <-handled:
+		t.Fatal("expected paused register to not handle messages")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := l.Resume(); err != nil {
+		t.Fatalf("expected to resume: %v", err)
+	}
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		t.Fatal("expected resumed register to handle messages")
+	}
+	if info := l.Info(); !info.Consuming || info.Paused || info.LastProcessed.IsZero() {
+		t.Fatalf("expected register to be consuming: %+v", info)
+	}
+}
+
+func testDrain(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	broker := inmem.NewBroker()
+	release := make(chan struct{})
+	handler.HandleFunc = func(ctx context.Context, m *message.Message) error {
+		<-release
+		return m.Ack(false)
+	}
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) {}
+
+	l, err := New("key", "ex", broker, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go l.Run(ctx)
+	broker.Publish("ex", "key", amqp.Publishing{Body: []byte(`foo`)})
+	for i := 0; i < 10 && l.Info().InFlight == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- l.Drain(ctx) }()
+	select {
+	case <-drained:
+		t.Fatal("expected drain to wait for in flight messages")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("expected to drain: %v", err)
+	}
+	if broker.Unacked() != 0 {
+		t.Fatalf("unexpected unacked count: %d", broker.Unacked())
+	}
+}
+
+func testSetPrefetch(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return make(chan amqp.Delivery), nil }
+	consumer.CancelFunc = func(key string) error { return nil }
+	consumer.QosFunc = func(prefetch int) error {
+		if prefetch != 10 {
+			t.Fatalf("unexpected prefetch: %d", prefetch)
+		}
+		return nil
+	}
+
+	l, err := New("key", "ex", consumer, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.SetPrefetch(-1); err != ErrInvalidPrefetch {
+		t.Fatalf("expected to have ErrInvalidPrefetch: %v", err)
+	}
+	if err := l.SetPrefetch(10); err != nil {
+		t.Fatalf("expected to set prefetch: %v", err)
+	}
+	if !consumer.QosInvoked {
+		t.Fatal("expected consumer.Qos() to be invoked")
+	}
+	if !consumer.CancelInvoked {
+		t.Fatal("expected consumer.Cancel() to be invoked")
+	}
+	if l.Info().Prefetch != 10 {
+		t.Fatalf("unexpected prefetch: %d", l.Info().Prefetch)
+	}
+}
+
+func testHandleShardedMessagesInOrder(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	msgchan := make(chan amqp.Delivery)
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return msgchan, nil }
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) {}
+
+	// user 1 and 3 hash to the same worker, user 2 to the other one.
+	release := make(chan struct{})
+	handled := make(chan string, 3)
+	h := &blockingHandler{release: release, handled: handled}
+
+	l, err := New("key", "ex", consumer, h, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.Shard(2, HeaderKey("user_id")); err != nil {
+		t.Fatalf("expected to shard: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go l.Run(ctx)
+
+	for _, id := range []string{"1", "3", "2"} {
+		msgchan <- amqp.Delivery{Headers: amqp.Table{"user_id": id}, Body: []byte(id)}
+	}
+	if got := <-handled; got != "2" {
+		t.Fatalf("expected other users to be handled while user 1 blocks: %s", got)
+	}
+
+	close(release)
+	for _, want := range []string{"1", "3"} {
+		if got := <-handled; got != want {
+			t.Fatalf("expected messages of a worker in order: %s", got)
+		}
+	}
+	if l.Info().Workers != 2 {
+		t.Fatalf("unexpected workers: %d", l.Info().Workers)
+	}
+}
+
+func testFailToShardOnNoWorker(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return make(chan amqp.Delivery), nil }
+
+	l, err := New("key", "ex", consumer, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.Shard(0, HeaderKey("user_id")); err != ErrInvalidWorkers {
+		t.Fatalf("expected to have ErrInvalidWorkers: %v", err)
+	}
+	if _, err := ParseShardKey("query:id"); !errors.Is(err, ErrInvalidShardKey) {
+		t.Fatalf("expected to have ErrInvalidShardKey: %v", err)
+	}
+}
+
+func testReadShardKey(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	m := amqp.Delivery{
+		Headers:     amqp.Table{"user_id": int64(7)},
+		ContentType: "application/json",
+		Body:        []byte(`{"id": 42, "username": "foo"}`),
+	}
+	for key, want := range map[string]string{"body:id": "42", "header:user_id": "7", "body:email": ""} {
+		shardKey, err := ParseShardKey(key)
+		if err != nil {
+			t.Fatalf("expected to parse shard key: %v", err)
+		}
+		if got := shardKey(m); got != want {
+			t.Fatalf("unexpected %s key: %q", key, got)
+		}
+	}
+
+	// protobuf bodies don't decode in a map.
+	m = amqp.Delivery{MessageId: "1", ContentType: "application/x-protobuf", Body: []byte{0x08, 0x2a}}
+	if got := BodyKey("id")(m); got != "1" {
+		t.Fatalf("expected undecodable body to be sharded on its message id: %q", got)
+	}
+}
+
+func testHandleBatch(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	msgchan := make(chan amqp.Delivery)
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return msgchan, nil }
+	events := make(chan srvstats.Event, 4)
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) { events <- e }
+
+	// messages with a "fail" body fail, "drop" ones are acked despite failing and "skip" ones are left unsettled.
+	h := &batchHandler{sizes: make(chan int, 1)}
+	l, err := New("key", "ex", consumer, h, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.Batch(4, time.Minute); err != nil {
+		t.Fatalf("expected to batch: %v", err)
+	}
+	if err := l.Shard(2, HeaderKey("user_id")); err != ErrShardedBatch {
+		t.Fatalf("expected to have ErrShardedBatch: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go l.Run(ctx)
+
+	acker := new(tagAcker)
+	for i, body := range []string{"ok", "fail", "drop", "skip"} {
+		msgchan <- amqp.Delivery{Acknowledger: acker, DeliveryTag: uint64(i + 1), Body: []byte(body)}
+	}
+	if size := <-h.sizes; size != 4 {
+		t.Fatalf("expected messages to be handled in a single batch: %d", size)
+	}
+
+	outcomes := make(map[srvstats.Outcome]int)
+	for i := 0; i < 4; i++ {
+		outcomes[(<-events).Outcome]++
+	}
+	if outcomes[srvstats.Success] != 1 || outcomes[srvstats.Retried] != 2 || outcomes[srvstats.Dropped] != 1 {
+		t.Fatalf("unexpected outcomes: %v", outcomes)
+	}
+
+	want := []string{"nack 2", "nack 4", "ack 3 multiple"}
+	if len(acker.calls) != len(want) {
+		t.Fatalf("unexpected acknowledgements: %v", acker.calls)
+	}
+	for i, call := range want {
+		if acker.calls[i] != call {
+			t.Fatalf("expected failed messages to be nacked before the multiple ack: %v", acker.calls)
+		}
+	}
+	if l.Info().BatchSize != 4 {
+		t.Fatalf("unexpected batch size: %d", l.Info().BatchSize)
+	}
+}
+
+func testAckBatchWithParkedMessages(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	msgchan := make(chan amqp.Delivery)
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return msgchan, nil }
+	events := make(chan srvstats.Event, 3)
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) { events <- e }
+
+	h := &batchHandler{sizes: make(chan int, 1)}
+	l, err := New("key", "ex", consumer, h, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.Batch(3, time.Minute); err != nil {
+		t.Fatalf("expected to batch: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go l.Run(ctx)
+
+	acker := new(tagAcker)
+	for i, body := range []string{"ok", "park", "ok"} {
+		msgchan <- amqp.Delivery{Acknowledger: acker, DeliveryTag: uint64(i + 1), Body: []byte(body)}
+	}
+	<-h.sizes
+
+	outcomes := make(map[srvstats.Outcome]int)
+	for i := 0; i < 3; i++ {
+		outcomes[(<-events).Outcome]++
+	}
+	if outcomes[srvstats.Success] != 2 || outcomes[srvstats.Parked] != 1 {
+		t.Fatalf("unexpected outcomes: %v", outcomes)
+	}
+	if strings.Join(acker.calls, ",") != "ack 1,ack 3" {
+		t.Fatalf("expected messages to be acked one by one around the parked one: %v", acker.calls)
+	}
+
+	// released once the batch was acked, the parked message is acked on its own.
+	if err := h.parked[0].Ack(false); err != nil {
+		t.Fatalf("expected to ack parked message: %v", err)
+	}
+	if strings.Join(acker.calls, ",") != "ack 1,ack 3,ack 2" {
+		t.Fatalf("expected parked message to be acked: %v", acker.calls)
+	}
+}
+
+func testFailToBatchUnsupportedHandler(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return make(chan amqp.Delivery), nil }
+
+	l, err := New("key", "ex", consumer, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.Batch(10, time.Second); err != ErrBatchUnsupported {
+		t.Fatalf("expected to have ErrBatchUnsupported: %v", err)
+	}
+	if err := l.Batch(0, time.Second); err != ErrInvalidBatch {
+		t.Fatalf("expected to have ErrInvalidBatch: %v", err)
+	}
+}
+
+func testRequeueTimedOutMessage(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	msgchan := make(chan amqp.Delivery)
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return msgchan, nil }
+	events := make(chan srvstats.Event, 1)
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) { events <- e }
+
+	// the handler gives up once its deadline expires, leaving the message unsettled.
+	returned := make(chan struct{})
+	handler.HandleFunc = func(ctx context.Context, m *message.Message) error {
+		defer close(returned)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	l, err := New("key", "ex", consumer, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.SetTimeout(-time.Second); err != ErrInvalidTimeout {
+		t.Fatalf("expected to have ErrInvalidTimeout: %v", err)
+	}
+	if err := l.SetTimeout(20 * time.Millisecond); err != nil {
+		t.Fatalf("expected to set timeout: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go l.Run(ctx)
+
+	acker := new(tagAcker)
+	msgchan <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 1, Body: []byte("foo")}
+	if e := <-events; e.Outcome != srvstats.TimedOut {
+		t.Fatalf("unexpected outcome: %s", e.Outcome)
+	}
+	if len(acker.calls) != 1 || acker.calls[0] != "nack 1" {
+		t.Fatalf("expected message to be requeued: %v", acker.calls)
+	}
+	select {
+	case <-returned:
+	default:
+		t.Fatal("expected message to be requeued once the handler returned")
+	}
+}
+
+func testAbandonStuckHandler(t *testing.T, consumer *mock.Consumer, handler *mock.Handler, stats *mock.Stats) {
+	msgchan := make(chan amqp.Delivery)
+	consumer.ConsumeFunc = func(key, ex string) (<-chan amqp.Delivery, error) { return msgchan, nil }
+	events := make(chan srvstats.Event, 1)
+	stats.TrackFunc = func(tm time.Time, e srvstats.Event) { events <- e }
+
+	// the handler is blocked on a call which ignores its deadline.
+	unblock := make(chan struct{})
+	handler.HandleFunc = func(ctx context.Context, m *message.Message) error {
+		<-unblock
+		return m.Ack(false)
+	}
+
+	l, err := New("key", "ex", consumer, handler, stats, logger)
+	if err != nil {
+		t.Fatalf("expected to create new listener: %v", err)
+	}
+	if err := l.SetTimeout(20 * time.Millisecond); err != nil {
+		t.Fatalf("expected to set timeout: %v", err)
+	}
+	l.grace = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go l.Run(ctx)
+
+	acker := new(tagAcker)
+	msgchan <- amqp.Delivery{Acknowledger: acker, DeliveryTag: 1, Body: []byte("foo")}
+	if e := <-events; e.Outcome != srvstats.TimedOut {
+		t.Fatalf("unexpected outcome: %s", e.Outcome)
+	}
+	if len(acker.calls) != 1 || acker.calls[0] != "nack 1" {
+		t.Fatalf("expected message to be requeued: %v", acker.calls)
+	}
+	if err := l.Check(ctx); err != ErrStuck {
+		t.Fatalf("expected to have ErrStuck: %v", err)
+	}
+
+	close(unblock)
+	for i := 0; i < 10 && l.Check(ctx) != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := l.Check(ctx); err != nil {
+		t.Fatalf("expected register to be healthy once the handler returned: %v", err)
+	}
+	if len(acker.calls) != 1 {
+		t.Fatalf("expected abandoned message to not be settled again: %v", acker.calls)
+	}
+}
+
+// batchHandler fails the messages with a fail body, drops the drop ones, leaves the skip ones unsettled
+// and parks the park ones.
+type batchHandler struct {
+	sizes  chan int
+	parked []*message.Message
+}
+
+func (h *batchHandler) Handle(ctx context.Context, m *message.Message) error {
+	return h.HandleBatch(ctx, []*message.Message{m})[0]
+}
+
+func (h *batchHandler) HandleBatch(ctx context.Context, msgs []*message.Message) []error {
+	h.sizes <- len(msgs)
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		switch string(m.Body) {
+		case "fail":
+			m.Nack(false, true)
+			errs[i] = errors.New("handler error")
+		case "drop":
+			m.Ack(false)
+			errs[i] = errors.New("handler error")
+		case "skip":
+			errs[i] = errors.New("handler error")
+		case "park":
+			h.parked = append(h.parked, m)
+			errs[i] = message.ErrParked
+		default:
+			m.Ack(false)
+		}
+	}
+
+	return errs
+}
+
+// tagAcker records the acknowledgements of deliveries.
+type tagAcker struct {
+	calls []string
+}
+
+func (a *tagAcker) Ack(tag uint64, multiple bool) error {
+	call := fmt.Sprintf("ack %d", tag)
+	if multiple {
+		call += " multiple"
+	}
+	a.calls = append(a.calls, call)
+	return nil
+}
+
+func (a *tagAcker) Nack(tag uint64, multiple, requeue bool) error {
+	a.calls = append(a.calls, fmt.Sprintf("nack %d", tag))
+	return nil
+}
+
+func (a *tagAcker) Reject(tag uint64, requeue bool) error {
+	a.calls = append(a.calls, fmt.Sprintf("reject %d", tag))
+	return nil
+}
+
+// blockingHandler blocks handling the messages of user 1 until released.
+type blockingHandler struct {
+	release <-chan struct{}
+	handled chan<- string
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, m *message.Message) error {
+	if string(m.Body) == "1" {
+		<-h.release
+	}
+	h.handled <- string(m.Body)
+	return nil
+}
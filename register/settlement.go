@@ -0,0 +1,117 @@
+package register
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/stats"
+)
+
+// errExpired is returned when a handler settles a message after it timed out.
+var errExpired = errors.New("message handling timed out")
+
+type (
+	// settlement records how the handler acknowledged a message.
+	settlement struct {
+		message.Acknowledger
+		mu       sync.Mutex
+		acked    bool
+		requeued bool
+		rejected bool
+		expired  bool
+	}
+)
+
+func (s *settlement) Ack(multiple bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired {
+		return errExpired
+	}
+	if err := s.Acknowledger.Ack(multiple); err != nil {
+		return err
+	}
+
+	s.acked = true
+	return nil
+}
+
+func (s *settlement) Nack(multiple, requeue bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired {
+		return errExpired
+	}
+	if err := s.Acknowledger.Nack(multiple, requeue); err != nil {
+		return err
+	}
+
+	s.settleNegative(requeue)
+	return nil
+}
+
+func (s *settlement) Reject(requeue bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired {
+		return errExpired
+	}
+	if err := s.Acknowledger.Reject(requeue); err != nil {
+		return err
+	}
+
+	s.settleNegative(requeue)
+	return nil
+}
+
+// expire stops the handler from settling the message, which is requeued when left unsettled.
+func (s *settlement) expire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired = true
+	if s.acked || s.requeued || s.rejected {
+		return nil
+	}
+	if err := s.Acknowledger.Nack(false, true); err != nil {
+		return err
+	}
+
+	s.requeued = true
+	return nil
+}
+
+func (s *settlement) settleNegative(requeue bool) {
+	if requeue {
+		s.requeued = true
+		return
+	}
+	s.rejected = true
+}
+
+// outcome classifies the handling given the error returned by the handler.
+func (s *settlement) outcome(err error) stats.Outcome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case err == nil:
+		return stats.Success
+	case err == message.ErrParked:
+		return stats.Parked
+	case err == ErrTimeout:
+		return stats.TimedOut
+	case s.requeued:
+		return stats.Retried
+	case s.rejected:
+		return stats.DeadLettered
+	case s.acked:
+		return stats.Dropped
+	default:
+		return stats.Failed
+	}
+}
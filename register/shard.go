@@ -0,0 +1,144 @@
+package register
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/rafaeljesus/srv-consumer/platform/message"
+	"github.com/rafaeljesus/srv-consumer/platform/message/codec"
+	"github.com/streadway/amqp"
+)
+
+// shardBuffer is how many messages are queued to a worker ahead of being handled.
+const shardBuffer = 16
+
+var (
+	// ErrInvalidWorkers is returned when sharding on less than one worker.
+	ErrInvalidWorkers = errors.New("invalid workers")
+	// ErrInvalidShardKey is returned when parsing a shard key which is neither header:name nor body:field.
+	ErrInvalidShardKey = errors.New("invalid shard key")
+)
+
+type (
+	// ShardKey returns the key a message is sharded on, messages with the same key are handled in order.
+	ShardKey func(m amqp.Delivery) string
+)
+
+// HeaderKey returns the ShardKey reading the header with the given name.
+func HeaderKey(name string) ShardKey {
+	return func(m amqp.Delivery) string {
+		v, ok := m.Headers[name]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// BodyKey returns the ShardKey reading the top level field of the decoded body, e.g. id. Only JSON and
+// msgpack bodies decode in a map, the others, such as protobuf ones, are sharded on their message id, so
+// they are spread on the workers without keeping their order; shard them on a HeaderKey instead.
+// Bodies are decoded before being dispatched to the workers.
+func BodyKey(field string) ShardKey {
+	var warn sync.Once
+	return func(m amqp.Delivery) string {
+		msg, err := message.FromDelivery(m)
+		if err == nil {
+			var fields map[string]interface{}
+			if err = codec.Default.Decode(msg, &fields); err == nil {
+				v, ok := fields[field]
+				if !ok {
+					return ""
+				}
+				return fmt.Sprint(v)
+			}
+		}
+
+		warn.Do(func() {
+			slog.Warn("failed to read shard key from body, sharding on message id", "field", field, "content_type", m.ContentType, "error", err)
+		})
+		return m.MessageId
+	}
+}
+
+// ParseShardKey returns the ShardKey described by s, either header:name or body:field.
+func ParseShardKey(s string) (ShardKey, error) {
+	source, name, ok := strings.Cut(s, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidShardKey, s)
+	}
+
+	switch source {
+	case "header":
+		return HeaderKey(name), nil
+	case "body":
+		return BodyKey(name), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrInvalidShardKey, s)
+}
+
+// Shard handles the messages on the given number of workers, each message going to the
+// worker its key hashes to, so messages with the same key are handled in order while the
+// others are handled in parallel. It can't spread batches on more than one worker and must be called before Run.
+func (r *Register) Shard(workers int, key ShardKey) error {
+	if workers < 1 {
+		return ErrInvalidWorkers
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if workers > 1 && r.batchSize > 0 {
+		return ErrShardedBatch
+	}
+
+	r.workers = workers
+	r.shardKey = key
+	return nil
+}
+
+// shards runs the workers handling the messages sent to the returned dispatch function,
+// until the returned stop function is called, which waits for the queued messages to be handled.
+func (r *Register) shards(ctx context.Context, workers int, key ShardKey) (dispatch func(amqp.Delivery), stop func()) {
+	var wg sync.WaitGroup
+	queues := make([]chan amqp.Delivery, workers)
+	for i := range queues {
+		queues[i] = make(chan amqp.Delivery, shardBuffer)
+		wg.Add(1)
+		go func(queue <-chan amqp.Delivery) {
+			defer wg.Done()
+			for m := range queue {
+				r.handle(ctx, m)
+				r.end()
+				r.beat()
+			}
+		}(queues[i])
+	}
+
+	dispatch = func(m amqp.Delivery) {
+		h := fnv.New32a()
+		h.Write([]byte(key(m)))
+		// counted in flight while queued, so Drain waits for it.
+		r.begin()
+		select {
+		case queues[h.Sum32()%uint32(workers)] <- m:
+		case <-ctx.Done():
+			// left unacknowledged, it is delivered again.
+			r.end()
+		}
+	}
+	stop = func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}
+
+	return dispatch, stop
+}
@@ -0,0 +1,98 @@
+package register
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// abandonGrace is how long a handler may keep running once its deadline expired before it is abandoned,
+// e.g. when blocked on a store call which doesn't honour the context.
+const abandonGrace = 5 * time.Second
+
+var (
+	// ErrStuck is returned by Check while handlers abandoned after their deadline are still running.
+	ErrStuck = errors.New("register has handlers stuck past their deadline")
+	// ErrTimeout is the error of the messages which handling exceeded the register timeout.
+	ErrTimeout = errors.New("message handling timeout")
+	// ErrInvalidTimeout is returned when setting a negative timeout.
+	ErrInvalidTimeout = errors.New("invalid timeout")
+)
+
+// SetTimeout bounds how long handling a message may take, the context passed to the handler
+// is done once it expires and the message is requeued when the handler returns. Batches are bounded
+// as a whole by the same timeout. A zero timeout doesn't bound it.
+func (r *Register) SetTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return ErrInvalidTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.timeout = timeout
+	return nil
+}
+
+// handleTimeout returns how long handling a message may take.
+func (r *Register) handleTimeout() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.timeout
+}
+
+// timedOut tells whether err was caused by the expiry of the handling deadline.
+func timedOut(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// abandon marks the register unhealthy until the abandoned handler returns, closing done.
+func (r *Register) abandon(done <-chan struct{}) {
+	r.mu.Lock()
+	r.abandoned++
+	r.mu.Unlock()
+
+	go func() {
+		<-done
+		r.mu.Lock()
+		r.abandoned--
+		r.mu.Unlock()
+	}()
+}
+
+// withTimeout calls fn with ctx bounded by timeout, unless zero, and returns whether the timeout
+// expired before fn returned. It waits for fn to give up, so the message is only settled once the
+// handler is done with it, but no longer than grace past the deadline: fn is then abandoned, its
+// zero result returned along with a channel closed once it returns, which is nil otherwise.
+func withTimeout[T any](ctx context.Context, timeout, grace time.Duration, fn func(ctx context.Context) T) (T, bool, <-chan struct{}) {
+	if timeout <= 0 {
+		return fn(ctx), false, nil
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, ErrTimeout)
+	var res T
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer cancel()
+		res = fn(ctx)
+	}()
+
+	select {
+	case <-done:
+		return res, context.Cause(ctx) == ErrTimeout, nil
+	case <-ctx.Done():
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return res, true, nil
+	case <-timer.C:
+		var zero T
+		return zero, true, done
+	}
+}
@@ -0,0 +1,113 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/storage"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// filename is the database file created in the DSN directory.
+	filename = "srv-consumer.db"
+	// openTimeout bounds the wait for the file lock held by another process.
+	openTimeout = 5 * time.Second
+)
+
+var (
+	// make sure Storage satisfies storage.Store interface.
+	_ storage.Store = (*Storage)(nil)
+	// make sure Storage satisfies srv.AuditLog interface.
+	_ srv.AuditLog = (*Storage)(nil)
+	// make sure Storage satisfies srv.Outbox interface.
+	_ srv.Outbox = (*Storage)(nil)
+
+	usersBucket     = []byte("users")
+	usernamesBucket = []byte("usernames")
+	changesBucket   = []byte("changes")
+	outboxBucket    = []byte("outbox")
+
+	// ErrInvalidDSN is returned when the DSN is not a file:// URL.
+	ErrInvalidDSN = errors.New("invalid file dsn")
+)
+
+type (
+	// Storage manages the bbolt backed file storage implementation.
+	// Every write is an fsynced transaction, so it survives crashes.
+	Storage struct {
+		Driver string
+		db     *bbolt.DB
+	}
+)
+
+func init() {
+	storage.Register("file", func(dsn string) (storage.Store, error) {
+		return New(dsn)
+	})
+}
+
+// New opens the storage in the directory of the given DSN, e.g. file:///var/lib/srv-consumer,
+// creating it when missing.
+func New(dsn string) (*Storage, error) {
+	dir, err := path(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bbolt.Open(filepath.Join(dir, filename), 0o600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range [][]byte{usersBucket, usernamesBucket, changesBucket, outboxBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Storage{Driver: "file", db: db}, nil
+}
+
+// Ping checks the database is open.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bbolt.Tx) error { return nil })
+}
+
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// path returns the directory of a file:// DSN, relative ones such as file://data included.
+func path(dsn string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", ErrInvalidDSN
+	}
+
+	dir := u.Host + u.Path
+	if dir == "" {
+		return "", ErrInvalidDSN
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,72 @@
+package bolt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/storage/storetest"
+)
+
+func TestUserStore(t *testing.T) {
+	storetest.RunUserStoreTests(t, func(t *testing.T) srv.UserStore {
+		s, err := New("file://" + t.TempDir())
+		if err != nil {
+			t.Fatalf("expected to open storage: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestStorage(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, string)
+	}{
+		{
+			"persist users across restarts",
+			testPersistAcrossRestarts,
+		},
+		{
+			"fail to open invalid dsn",
+			testFailToOpenInvalidDSN,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t, "file://"+t.TempDir())
+		})
+	}
+}
+
+func testPersistAcrossRestarts(t *testing.T, dsn string) {
+	s, err := New(dsn)
+	if err != nil {
+		t.Fatalf("expected to open storage: %v", err)
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	s.Close()
+
+	s, err = New(dsn)
+	if err != nil {
+		t.Fatalf("expected to reopen storage: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+	if err := s.Save(context.Background(), &srv.User{ID: 1, Username: "foo", Status: "active", Revision: 1}); err != nil {
+		t.Fatalf("expected to save persisted user: %v", err)
+	}
+}
+
+func testFailToOpenInvalidDSN(t *testing.T, dsn string) {
+	if _, err := New("memory://localhost"); err != ErrInvalidDSN {
+		t.Fatalf("expected to have ErrInvalidDSN: %v", err)
+	}
+}
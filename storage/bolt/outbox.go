@@ -0,0 +1,59 @@
+package bolt
+
+import (
+	"encoding/json"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"go.etcd.io/bbolt"
+)
+
+// Pending returns up to limit messages not sent yet, oldest first.
+func (s *Storage) Pending(limit int) ([]srv.OutboxMessage, error) {
+	var msgs []srv.OutboxMessage
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, data := c.First(); k != nil && len(msgs) < limit; k, data = c.Next() {
+			var m srv.OutboxMessage
+			if err := json.Unmarshal(data, &m); err != nil {
+				return err
+			}
+			msgs = append(msgs, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// MarkSent removes the messages with the given ids from the pending ones.
+func (s *Storage) MarkSent(ids ...uint64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		outbox := tx.Bucket(outboxBucket)
+		for _, id := range ids {
+			if err := outbox.Delete(key(uint(id))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// appendMessage stores the message keyed by its sequence, so the messages are ordered.
+func appendMessage(tx *bbolt.Tx, m srv.OutboxMessage) error {
+	outbox := tx.Bucket(outboxBucket)
+	seq, err := outbox.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	m.ID = seq
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return outbox.Put(key(uint(seq)), data)
+}
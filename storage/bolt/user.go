@@ -0,0 +1,311 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"go.etcd.io/bbolt"
+)
+
+type (
+	// scope is recorded along with the mutations of a scoped storage.
+	scope struct {
+		cause  srv.Cause
+		causes []srv.Cause
+		emit   srv.Emitter
+	}
+
+	// scoped is the storage recording its scope along with its mutations.
+	scoped struct {
+		*Storage
+		scope
+	}
+)
+
+// Add a new user to the store.
+func (s *Storage) Add(ctx context.Context, user *srv.User) error {
+	return s.add(ctx, user, scope{})
+}
+
+// Save a user to the store.
+func (s *Storage) Save(ctx context.Context, user *srv.User) error {
+	return s.save(ctx, user, scope{})
+}
+
+// AddMany adds the users in a single transaction, returning the error of each one, nil when added.
+func (s *Storage) AddMany(ctx context.Context, users []*srv.User) []error {
+	return s.addMany(ctx, users, scope{})
+}
+
+// SaveMany saves the users in a single transaction, returning the error of each one, nil when saved.
+func (s *Storage) SaveMany(ctx context.Context, users []*srv.User) []error {
+	return s.saveMany(ctx, users, scope{})
+}
+
+// WithCause returns the storage recording c as the cause of its mutations.
+func (s *Storage) WithCause(c srv.Cause) srv.UserStore {
+	return &scoped{s, scope{cause: c}}
+}
+
+// WithCauses returns the storage recording causes[i] as the cause of the mutation of the i-th user of its bulk operations.
+func (s *Storage) WithCauses(causes []srv.Cause) srv.UserStore {
+	return &scoped{s, scope{causes: causes}}
+}
+
+// WithOutbox returns the storage recording the messages emitted for its mutations.
+func (s *Storage) WithOutbox(emit srv.Emitter) srv.UserStore {
+	return &scoped{s, scope{emit: emit}}
+}
+
+// Add a new user to the store.
+func (s *scoped) Add(ctx context.Context, user *srv.User) error {
+	return s.add(ctx, user, s.scope)
+}
+
+// Save a user to the store.
+func (s *scoped) Save(ctx context.Context, user *srv.User) error {
+	return s.save(ctx, user, s.scope)
+}
+
+// AddMany adds the users in a single transaction, returning the error of each one, nil when added.
+func (s *scoped) AddMany(ctx context.Context, users []*srv.User) []error {
+	return s.addMany(ctx, users, s.scope)
+}
+
+// SaveMany saves the users in a single transaction, returning the error of each one, nil when saved.
+func (s *scoped) SaveMany(ctx context.Context, users []*srv.User) []error {
+	return s.saveMany(ctx, users, s.scope)
+}
+
+// WithCause returns the storage recording c as the cause of its mutations.
+func (s *scoped) WithCause(c srv.Cause) srv.UserStore {
+	return &scoped{s.Storage, scope{cause: c, emit: s.emit}}
+}
+
+// WithCauses returns the storage recording causes[i] as the cause of the mutation of the i-th user of its bulk operations.
+func (s *scoped) WithCauses(causes []srv.Cause) srv.UserStore {
+	return &scoped{s.Storage, scope{causes: causes, emit: s.emit}}
+}
+
+// WithOutbox returns the storage recording the messages emitted for its mutations.
+func (s *scoped) WithOutbox(emit srv.Emitter) srv.UserStore {
+	return &scoped{s.Storage, scope{cause: s.cause, causes: s.causes, emit: emit}}
+}
+
+// at returns the scope of the i-th user of a bulk operation.
+func (sc scope) at(i int) scope {
+	if i < len(sc.causes) {
+		sc.cause = sc.causes[i]
+	}
+
+	return sc
+}
+
+func (s *Storage) add(ctx context.Context, user *srv.User, sc scope) error {
+	return s.many(ctx, []*srv.User{user}, sc, addTx)[0]
+}
+
+func (s *Storage) save(ctx context.Context, user *srv.User, sc scope) error {
+	return s.many(ctx, []*srv.User{user}, sc, saveTx)[0]
+}
+
+func (s *Storage) addMany(ctx context.Context, users []*srv.User, sc scope) []error {
+	return s.many(ctx, users, sc, addTx)
+}
+
+func (s *Storage) saveMany(ctx context.Context, users []*srv.User, sc scope) []error {
+	return s.many(ctx, users, sc, saveTx)
+}
+
+// many mutates the users with fn in a single transaction. The users failing with a store error,
+// such as srv.ErrConflict, are skipped while any other error fails them all. The id and revision
+// of the mutated users are only updated once the transaction is committed. As bolt transactions can't
+// be interrupted, ctx is checked between the users.
+func (s *Storage) many(ctx context.Context, users []*srv.User, sc scope, fn func(tx *bbolt.Tx, user srv.User, sc scope) (srv.User, error)) []error {
+	errs := make([]error, len(users))
+	mutated := make([]srv.User, len(users))
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		for i, user := range users {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			u, err := fn(tx, *user, sc.at(i))
+			switch err {
+			case nil:
+				mutated[i] = u
+			case srv.ErrConflict, srv.ErrNotFound, srv.ErrVersionMismatch:
+				errs[i] = err
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	for i, user := range users {
+		if errs[i] == nil {
+			user.ID, user.Revision = mutated[i].ID, mutated[i].Revision
+		}
+	}
+
+	return errs
+}
+
+func addTx(tx *bbolt.Tx, u srv.User, sc scope) (srv.User, error) {
+	usernames := tx.Bucket(usernamesBucket)
+	if usernames.Get([]byte(u.Username)) != nil {
+		return u, srv.ErrConflict
+	}
+
+	id, err := tx.Bucket(usersBucket).NextSequence()
+	if err != nil {
+		return u, err
+	}
+
+	u.ID = uint(id)
+	u.Revision = 1
+	return u, record(tx, nil, &u, sc)
+}
+
+func saveTx(tx *bbolt.Tx, u srv.User, sc scope) (srv.User, error) {
+	data := tx.Bucket(usersBucket).Get(key(u.ID))
+	if data == nil {
+		return u, srv.ErrNotFound
+	}
+
+	var old srv.User
+	if err := json.Unmarshal(data, &old); err != nil {
+		return u, err
+	}
+	if old.Revision != u.Revision {
+		return u, srv.ErrVersionMismatch
+	}
+	if old.Username != u.Username {
+		usernames := tx.Bucket(usernamesBucket)
+		if id := usernames.Get([]byte(u.Username)); id != nil && !bytes.Equal(id, key(u.ID)) {
+			return u, srv.ErrConflict
+		}
+		if err := usernames.Delete([]byte(old.Username)); err != nil {
+			return u, err
+		}
+	}
+
+	u.Revision++
+	return u, record(tx, &old, &u, sc)
+}
+
+// Find returns the user with the given id.
+func (s *Storage) Find(ctx context.Context, id uint) (*srv.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	user := new(srv.User)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(usersBucket).Get(key(id))
+		if data == nil {
+			return srv.ErrNotFound
+		}
+		return json.Unmarshal(data, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// History returns the changes of the user with the given id, oldest first.
+func (s *Storage) History(userID uint) ([]srv.Change, error) {
+	var changes []srv.Change
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		prefix := key(userID)
+		c := tx.Bucket(changesBucket).Cursor()
+		for k, data := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, data = c.Next() {
+			var change srv.Change
+			if err := json.Unmarshal(data, &change); err != nil {
+				return err
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// record puts the user mutated from before along with its change and emitted messages.
+func record(tx *bbolt.Tx, before, user *srv.User, sc scope) error {
+	now := time.Now()
+	change := srv.NewChange(before, user, sc.cause, now)
+	msgs, err := sc.emit.Emit(*user, change)
+	if err != nil {
+		return err
+	}
+
+	if err := put(tx, user); err != nil {
+		return err
+	}
+	if err := appendChange(tx, change); err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		m.CreatedAt = now.UTC()
+		if err := appendMessage(tx, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func put(tx *bbolt.Tx, user *srv.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(usersBucket).Put(key(user.ID), data); err != nil {
+		return err
+	}
+
+	return tx.Bucket(usernamesBucket).Put([]byte(user.Username), key(user.ID))
+}
+
+// appendChange stores the change keyed by the user id followed by a sequence,
+// so the changes of a user are contiguous and ordered.
+func appendChange(tx *bbolt.Tx, change srv.Change) error {
+	changes := tx.Bucket(changesBucket)
+	seq, err := changes.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	return changes.Put(append(key(change.UserID), key(uint(seq))...), data)
+}
+
+// key returns the big endian id, so users are ordered by id.
+func key(id uint) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
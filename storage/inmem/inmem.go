@@ -1,10 +1,23 @@
 package inmem
 
 import (
+	"context"
+	"errors"
+	"io/fs"
 	"reflect"
 	"sync"
 
 	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/storage"
+)
+
+var (
+	// make sure Storage satisfies storage.Store interface.
+	_ storage.Store = (*Storage)(nil)
+	// make sure Storage satisfies srv.AuditLog interface.
+	_ srv.AuditLog = (*Storage)(nil)
+	// make sure Storage satisfies srv.Outbox interface.
+	_ srv.Outbox = (*Storage)(nil)
 )
 
 type (
@@ -12,23 +25,67 @@ type (
 	Storage struct {
 		Driver  string
 		mu      sync.RWMutex
-		nextIDs map[interface{}]uint
+		nextIDs map[string]uint
 		users   map[uint]*srv.User
+		changes map[uint][]srv.Change
+		outbox  []srv.OutboxMessage
+
+		snapshots *snapshotter
 	}
 )
 
+func init() {
+	storage.Register("memory", func(dsn string) (storage.Store, error) {
+		return Open(dsn)
+	})
+}
+
 // New creates a new in memory storage.
 func New(dsn string) *Storage {
 	return &Storage{
 		Driver:  "inmem",
 		users:   make(map[uint]*srv.User),
-		nextIDs: make(map[interface{}]uint),
+		changes: make(map[uint][]srv.Change),
+		nextIDs: make(map[string]uint),
+	}
+}
+
+// Open creates a new in memory storage which, when the DSN has a snapshot parameter, e.g.
+// memory://localhost?snapshot=/var/lib/srv-consumer/users.snapshot&snapshot_interval=1m,
+// is restored from the snapshot file and written back to it on every interval and on Close.
+func Open(dsn string) (*Storage, error) {
+	s := New(dsn)
+
+	path, interval, err := snapshotOptions(dsn)
+	if err != nil || path == "" {
+		return s, err
 	}
+
+	if err := s.LoadSnapshot(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	s.snapshots = newSnapshotter(s, path, interval)
+
+	return s, nil
+}
+
+// Ping checks the storage is reachable, which is always the case in memory.
+func (s *Storage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close writes the last snapshot when snapshots are enabled, otherwise the users are dropped with the storage.
+func (s *Storage) Close() error {
+	if s.snapshots == nil {
+		return nil
+	}
+
+	return s.snapshots.close()
 }
 
 // nextID returns the next ID value that should be used for a struct of the given type.
 func (s *Storage) nextID(val interface{}) uint {
-	valType := reflect.TypeOf(reflect.Indirect(reflect.ValueOf(val)).Interface())
+	valType := reflect.TypeOf(reflect.Indirect(reflect.ValueOf(val)).Interface()).String()
 	s.nextIDs[valType]++
 	return s.nextIDs[valType]
 }
@@ -0,0 +1,14 @@
+package inmem
+
+import (
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/storage/storetest"
+)
+
+func TestUserStore(t *testing.T) {
+	storetest.RunUserStoreTests(t, func(t *testing.T) srv.UserStore {
+		return New("memory://localhost")
+	})
+}
@@ -0,0 +1,36 @@
+package inmem
+
+import "github.com/rafaeljesus/srv-consumer"
+
+// Pending returns up to limit messages not sent yet, oldest first.
+func (s *Storage) Pending(limit int) ([]srv.OutboxMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if limit > len(s.outbox) {
+		limit = len(s.outbox)
+	}
+
+	return append([]srv.OutboxMessage(nil), s.outbox[:limit]...), nil
+}
+
+// MarkSent removes the messages with the given ids from the pending ones.
+func (s *Storage) MarkSent(ids ...uint64) error {
+	sent := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		sent[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := s.outbox[:0]
+	for _, m := range s.outbox {
+		if !sent[m.ID] {
+			pending = append(pending, m)
+		}
+	}
+	s.outbox = pending
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package inmem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+const (
+	// snapshotVersion is the version of the snapshot format written.
+	snapshotVersion = 1
+	// defaultSnapshotInterval is used when the DSN sets a snapshot without an interval.
+	defaultSnapshotInterval = time.Minute
+)
+
+var (
+	// ErrUnsupportedSnapshotVersion is returned when restoring a snapshot written in an unknown format.
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported snapshot version")
+	// ErrSnapshotChecksum is returned when restoring a snapshot which data doesn't match its checksum.
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+)
+
+type (
+	// snapshotFile is the snapshot envelope, the checksum is the sha256 of the data bytes.
+	snapshotFile struct {
+		Version  int             `json:"version"`
+		Checksum string          `json:"checksum"`
+		Data     json.RawMessage `json:"data"`
+	}
+
+	// snapshotData is a point-in-time copy of the storage.
+	snapshotData struct {
+		TakenAt time.Time           `json:"taken_at"`
+		NextIDs map[string]uint     `json:"next_ids"`
+		Users   []srv.User          `json:"users"`
+		Changes []srv.Change        `json:"changes,omitempty"`
+		Outbox  []srv.OutboxMessage `json:"outbox,omitempty"`
+	}
+
+	// snapshotter writes the storage snapshot to a file on every interval.
+	snapshotter struct {
+		s       *Storage
+		path    string
+		done    chan struct{}
+		stopped chan struct{}
+		once    sync.Once
+		err     error
+	}
+)
+
+// Snapshot writes a point-in-time snapshot of the users, their changes, the pending messages and id counters to w.
+func (s *Storage) Snapshot(w io.Writer) error {
+	s.mu.RLock()
+	d := snapshotData{
+		TakenAt: time.Now().UTC(),
+		NextIDs: make(map[string]uint, len(s.nextIDs)),
+		Users:   make([]srv.User, 0, len(s.users)),
+	}
+	for k, v := range s.nextIDs {
+		d.NextIDs[k] = v
+	}
+	for _, u := range s.users {
+		d.Users = append(d.Users, *u)
+		d.Changes = append(d.Changes, s.changes[u.ID]...)
+	}
+	d.Outbox = append(d.Outbox, s.outbox...)
+	s.mu.RUnlock()
+	sort.Slice(d.Users, func(i, j int) bool { return d.Users[i].ID < d.Users[j].ID })
+	sort.SliceStable(d.Changes, func(i, j int) bool { return d.Changes[i].UserID < d.Changes[j].UserID })
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(snapshotFile{
+		Version:  snapshotVersion,
+		Checksum: checksum(data),
+		Data:     data,
+	})
+}
+
+// Restore replaces the storage content with the snapshot read from r.
+func (s *Storage) Restore(r io.Reader) error {
+	var f snapshotFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return err
+	}
+	if f.Version != snapshotVersion {
+		return fmt.Errorf("%v: %d", ErrUnsupportedSnapshotVersion, f.Version)
+	}
+	if f.Checksum != checksum(f.Data) {
+		return ErrSnapshotChecksum
+	}
+
+	var d snapshotData
+	if err := json.Unmarshal(f.Data, &d); err != nil {
+		return err
+	}
+
+	users := make(map[uint]*srv.User, len(d.Users))
+	for i := range d.Users {
+		users[d.Users[i].ID] = &d.Users[i]
+	}
+	changes := make(map[uint][]srv.Change)
+	for _, c := range d.Changes {
+		changes[c.UserID] = append(changes[c.UserID], c)
+	}
+	if d.NextIDs == nil {
+		d.NextIDs = make(map[string]uint)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = users
+	s.changes = changes
+	s.outbox = d.Outbox
+	s.nextIDs = d.NextIDs
+	return nil
+}
+
+// SaveSnapshot writes the snapshot to path atomically, through a synced temporary file renamed over it.
+func (s *Storage) SaveSnapshot(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.Snapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot restores the storage from the snapshot file at path.
+func (s *Storage) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.Restore(f)
+}
+
+func newSnapshotter(s *Storage, path string, interval time.Duration) *snapshotter {
+	sn := &snapshotter{
+		s:       s,
+		path:    path,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go sn.loop(interval)
+
+	return sn
+}
+
+func (sn *snapshotter) loop(interval time.Duration) {
+	defer close(sn.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := sn.s.SaveSnapshot(sn.path); err != nil {
+				slog.Warn("failed to write snapshot", "path", sn.path, "error", err)
+			}
+		case <-sn.done:
+			return
+		}
+	}
+}
+
+// close stops the loop and writes the last snapshot.
+func (sn *snapshotter) close() error {
+	sn.once.Do(func() {
+		close(sn.done)
+		<-sn.stopped
+		sn.err = sn.s.SaveSnapshot(sn.path)
+	})
+
+	return sn.err
+}
+
+// snapshotOptions returns the snapshot path and interval of the DSN query.
+func snapshotOptions(dsn string) (string, time.Duration, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", 0, err
+	}
+
+	q := u.Query()
+	path := q.Get("snapshot")
+	interval := defaultSnapshotInterval
+	if v := q.Get("snapshot_interval"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			return "", 0, err
+		}
+		if interval <= 0 {
+			return "", 0, fmt.Errorf("invalid snapshot interval: %s", v)
+		}
+	}
+
+	return path, interval, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
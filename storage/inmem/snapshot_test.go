@@ -0,0 +1,119 @@
+package inmem
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+func TestSnapshot(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, *Storage)
+	}{
+		{
+			"restore snapshot",
+			testRestoreSnapshot,
+		},
+		{
+			"fail to restore corrupted snapshot",
+			testFailToRestoreCorruptedSnapshot,
+		},
+		{
+			"fail to restore unsupported version",
+			testFailToRestoreUnsupportedVersion,
+		},
+		{
+			"persist across restarts",
+			testPersistAcrossRestarts,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			s := New("memory://localhost")
+			for _, username := range []string{"foo", "bar"} {
+				if err := s.Add(context.Background(), &srv.User{Username: username}); err != nil {
+					t.Fatalf("expected to add user: %v", err)
+				}
+			}
+			test.function(t, s)
+		})
+	}
+}
+
+func testRestoreSnapshot(t *testing.T, s *Storage) {
+	var buf bytes.Buffer
+	if err := s.Snapshot(&buf); err != nil {
+		t.Fatalf("expected to write snapshot: %v", err)
+	}
+
+	restored := New("memory://localhost")
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("expected to restore snapshot: %v", err)
+	}
+	if err := restored.Add(context.Background(), &srv.User{Username: "foo"}); err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+
+	user := &srv.User{Username: "baz"}
+	if err := restored.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	if user.ID != 3 {
+		t.Fatalf("expected id counter to be restored: %d", user.ID)
+	}
+
+	changes, err := restored.History(1)
+	if err != nil {
+		t.Fatalf("expected to find history: %v", err)
+	}
+	if len(changes) != 1 || changes[0].UserID != 1 {
+		t.Fatalf("expected history to be restored: %+v", changes)
+	}
+}
+
+func testFailToRestoreCorruptedSnapshot(t *testing.T, s *Storage) {
+	var buf bytes.Buffer
+	s.Snapshot(&buf)
+	corrupted := strings.Replace(buf.String(), `"foo"`, `"fox"`, 1)
+
+	if err := New("memory://localhost").Restore(strings.NewReader(corrupted)); err != ErrSnapshotChecksum {
+		t.Fatalf("expected to have ErrSnapshotChecksum: %v", err)
+	}
+}
+
+func testFailToRestoreUnsupportedVersion(t *testing.T, s *Storage) {
+	err := New("memory://localhost").Restore(strings.NewReader(`{"version":42,"checksum":"","data":{}}`))
+	if err == nil || !strings.Contains(err.Error(), ErrUnsupportedSnapshotVersion.Error()) {
+		t.Fatalf("expected to have ErrUnsupportedSnapshotVersion: %v", err)
+	}
+}
+
+func testPersistAcrossRestarts(t *testing.T, s *Storage) {
+	dsn := "memory://localhost?snapshot=" + filepath.Join(t.TempDir(), "users.snapshot") + "&snapshot_interval=1h"
+
+	first, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("expected to open storage: %v", err)
+	}
+	if err := first.Add(context.Background(), &srv.User{Username: "foo"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("expected to write snapshot on close: %v", err)
+	}
+
+	second, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("expected to open storage: %v", err)
+	}
+	defer second.Close()
+	if err := second.Add(context.Background(), &srv.User{Username: "foo"}); err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+}
@@ -1,33 +1,253 @@
 package inmem
 
-import "github.com/rafaeljesus/srv-consumer"
+import (
+	"context"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+type (
+	// scope is recorded along with the mutations of a scoped storage.
+	scope struct {
+		cause  srv.Cause
+		causes []srv.Cause
+		emit   srv.Emitter
+	}
+
+	// scoped is the storage recording its scope along with its mutations.
+	scoped struct {
+		*Storage
+		scope
+	}
+)
+
+// Add a new user to the store.
+func (s *Storage) Add(ctx context.Context, user *srv.User) error {
+	return s.add(ctx, user, scope{})
+}
+
+// Save a user to the store.
+func (s *Storage) Save(ctx context.Context, user *srv.User) error {
+	return s.save(ctx, user, scope{})
+}
+
+// AddMany adds the users at once, returning the error of each one, nil when added.
+func (s *Storage) AddMany(ctx context.Context, users []*srv.User) []error {
+	return s.addMany(ctx, users, scope{})
+}
+
+// SaveMany saves the users at once, returning the error of each one, nil when saved.
+func (s *Storage) SaveMany(ctx context.Context, users []*srv.User) []error {
+	return s.saveMany(ctx, users, scope{})
+}
+
+// Find returns the user with the given id.
+func (s *Storage) Find(ctx context.Context, id uint) (*srv.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	in, ok := s.users[id]
+	if !ok {
+		return nil, srv.ErrNotFound
+	}
+
+	u := *in
+	return &u, nil
+}
+
+// History returns the changes of the user with the given id, oldest first.
+func (s *Storage) History(userID uint) ([]srv.Change, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]srv.Change(nil), s.changes[userID]...), nil
+}
+
+// WithCause returns the storage recording c as the cause of its mutations.
+func (s *Storage) WithCause(c srv.Cause) srv.UserStore {
+	return &scoped{s, scope{cause: c}}
+}
+
+// WithCauses returns the storage recording causes[i] as the cause of the mutation of the i-th user of its bulk operations.
+func (s *Storage) WithCauses(causes []srv.Cause) srv.UserStore {
+	return &scoped{s, scope{causes: causes}}
+}
+
+// WithOutbox returns the storage recording the messages emitted for its mutations.
+func (s *Storage) WithOutbox(emit srv.Emitter) srv.UserStore {
+	return &scoped{s, scope{emit: emit}}
+}
 
 // Add a new user to the store.
-func (s *Storage) Add(user *srv.User) error {
+func (s *scoped) Add(ctx context.Context, user *srv.User) error {
+	return s.add(ctx, user, s.scope)
+}
+
+// Save a user to the store.
+func (s *scoped) Save(ctx context.Context, user *srv.User) error {
+	return s.save(ctx, user, s.scope)
+}
+
+// AddMany adds the users at once, returning the error of each one, nil when added.
+func (s *scoped) AddMany(ctx context.Context, users []*srv.User) []error {
+	return s.addMany(ctx, users, s.scope)
+}
+
+// SaveMany saves the users at once, returning the error of each one, nil when saved.
+func (s *scoped) SaveMany(ctx context.Context, users []*srv.User) []error {
+	return s.saveMany(ctx, users, s.scope)
+}
+
+// WithCause returns the storage recording c as the cause of its mutations.
+func (s *scoped) WithCause(c srv.Cause) srv.UserStore {
+	return &scoped{s.Storage, scope{cause: c, emit: s.emit}}
+}
+
+// WithCauses returns the storage recording causes[i] as the cause of the mutation of the i-th user of its bulk operations.
+func (s *scoped) WithCauses(causes []srv.Cause) srv.UserStore {
+	return &scoped{s.Storage, scope{causes: causes, emit: s.emit}}
+}
+
+// WithOutbox returns the storage recording the messages emitted for its mutations.
+func (s *scoped) WithOutbox(emit srv.Emitter) srv.UserStore {
+	return &scoped{s.Storage, scope{cause: s.cause, causes: s.causes, emit: emit}}
+}
+
+// at returns the scope of the i-th user of a bulk operation.
+func (sc scope) at(i int) scope {
+	if i < len(sc.causes) {
+		sc.cause = sc.causes[i]
+	}
+
+	return sc
+}
+
+func (s *Storage) add(ctx context.Context, user *srv.User, sc scope) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.addLocked(user, sc.at(0))
+}
+
+func (s *Storage) save(ctx context.Context, user *srv.User, sc scope) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saveLocked(user, sc.at(0))
+}
+
+func (s *Storage) addMany(ctx context.Context, users []*srv.User, sc scope) []error {
+	errs := make([]error, len(users))
+	if err := ctx.Err(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, user := range users {
+		errs[i] = s.addLocked(user, sc.at(i))
+	}
+
+	return errs
+}
+
+func (s *Storage) saveMany(ctx context.Context, users []*srv.User, sc scope) []error {
+	errs := make([]error, len(users))
+	if err := ctx.Err(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, user := range users {
+		errs[i] = s.saveLocked(user, sc.at(i))
+	}
+
+	return errs
+}
+
+func (s *Storage) addLocked(user *srv.User, sc scope) error {
 	for _, in := range s.users {
 		if in.Username == user.Username {
 			return srv.ErrConflict
 		}
 	}
 
-	user.ID = s.nextID(user)
-	s.users[user.ID] = user
+	u := *user
+	u.ID = s.nextID(user)
+	u.Revision = 1
+	if err := s.record(nil, &u, sc); err != nil {
+		return err
+	}
 
+	user.ID, user.Revision = u.ID, u.Revision
 	return nil
 }
 
-// Save a user to the store.
-func (s *Storage) Save(user *srv.User) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, ok := s.users[user.ID]; !ok {
+func (s *Storage) saveLocked(user *srv.User, sc scope) error {
+	in, ok := s.users[user.ID]
+	if !ok {
 		return srv.ErrNotFound
 	}
+	if in.Revision != user.Revision {
+		return srv.ErrVersionMismatch
+	}
+	if in.Username != user.Username {
+		for _, other := range s.users {
+			if other.Username == user.Username {
+				return srv.ErrConflict
+			}
+		}
+	}
+
+	u := *user
+	u.Revision++
+	if err := s.record(in, &u, sc); err != nil {
+		return err
+	}
+
+	user.Revision = u.Revision
+	return nil
+}
+
+// record stores the user mutated from before along with its change and emitted messages,
+// nothing is stored when the messages can't be emitted.
+func (s *Storage) record(before, user *srv.User, sc scope) error {
+	now := time.Now()
+	change := srv.NewChange(before, user, sc.cause, now)
+	msgs, err := sc.emit.Emit(*user, change)
+	if err != nil {
+		return err
+	}
 
 	s.users[user.ID] = user
+	s.changes[user.ID] = append(s.changes[user.ID], change)
+	for _, m := range msgs {
+		m.ID = uint64(s.nextID(m))
+		m.CreatedAt = now.UTC()
+		s.outbox = append(s.outbox, m)
+	}
+
 	return nil
 }
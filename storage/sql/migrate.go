@@ -0,0 +1,136 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationLock is the postgres advisory lock key serializing the migrations of replicas starting together.
+const migrationLock = 7349202801
+
+//go:embed migrations
+var migrations embed.FS
+
+type (
+	// migration is a schema change, versioned by its file name prefix, e.g. 0001_create_users.sql.
+	migration struct {
+		version int
+		name    string
+		query   string
+	}
+)
+
+// migrate applies the migrations of the storage dialect which were not applied yet,
+// each one in its own transaction. On postgres, it holds an advisory lock meanwhile,
+// so a replica waits for the migrations applied by another one before reading them.
+func (s *Storage) migrate(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if s.dialect == Postgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+			return fmt.Errorf("failed to lock migrations: %v", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock)
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	ms, err := load(s.dialect)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if applied[m.version] {
+			continue
+		}
+		if err := s.apply(ctx, conn, m); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %v", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) apply(ctx context.Context, conn *dbsql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.query); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), m.version, m.name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// load returns the embedded migrations of dialect sorted by version.
+func load(dialect string) ([]migration, error) {
+	dir := "migrations/" + dialect
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []migration
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration name %s: %v", e.Name(), err)
+		}
+		query, err := fs.ReadFile(migrations, dir+"/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		ms = append(ms, migration{version, e.Name(), string(query)})
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].version < ms[j].version })
+
+	return ms, nil
+}
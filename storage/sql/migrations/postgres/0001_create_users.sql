@@ -0,0 +1,7 @@
+CREATE TABLE users (
+	id       BIGSERIAL PRIMARY KEY,
+	username TEXT NOT NULL,
+	email    TEXT NOT NULL DEFAULT '',
+	status   TEXT NOT NULL DEFAULT '',
+	CONSTRAINT users_username_key UNIQUE (username)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE user_changes (
+	id          BIGSERIAL PRIMARY KEY,
+	user_id     BIGINT NOT NULL REFERENCES users (id),
+	revision    BIGINT NOT NULL,
+	diff        JSONB NOT NULL,
+	message_id  TEXT NOT NULL DEFAULT '',
+	routing_key TEXT NOT NULL DEFAULT '',
+	changed_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX user_changes_user_id_idx ON user_changes (user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE outbox (
+	id             BIGSERIAL PRIMARY KEY,
+	exchange       TEXT NOT NULL,
+	routing_key    TEXT NOT NULL,
+	message_id     TEXT NOT NULL DEFAULT '',
+	correlation_id TEXT NOT NULL DEFAULT '',
+	content_type   TEXT NOT NULL DEFAULT '',
+	body           BYTEA NOT NULL,
+	created_at     TIMESTAMPTZ NOT NULL,
+	sent_at        TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN revision BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,11 @@
+CREATE TABLE user_changes (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id     INTEGER NOT NULL REFERENCES users (id),
+	revision    BIGINT NOT NULL,
+	diff        TEXT NOT NULL,
+	message_id  TEXT NOT NULL DEFAULT '',
+	routing_key TEXT NOT NULL DEFAULT '',
+	changed_at  TIMESTAMP NOT NULL
+);
+
+CREATE INDEX user_changes_user_id_idx ON user_changes (user_id);
@@ -0,0 +1,13 @@
+CREATE TABLE outbox (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	exchange       TEXT NOT NULL,
+	routing_key    TEXT NOT NULL,
+	message_id     TEXT NOT NULL DEFAULT '',
+	correlation_id TEXT NOT NULL DEFAULT '',
+	content_type   TEXT NOT NULL DEFAULT '',
+	body           BLOB NOT NULL,
+	created_at     TIMESTAMP NOT NULL,
+	sent_at        TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
@@ -0,0 +1,58 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"strings"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+// Pending returns up to limit messages not sent yet, oldest first.
+func (s *Storage) Pending(limit int) ([]srv.OutboxMessage, error) {
+	rows, err := s.db.Query(
+		s.rebind("SELECT id, exchange, routing_key, message_id, correlation_id, content_type, body, created_at FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT ?"), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []srv.OutboxMessage
+	for rows.Next() {
+		var m srv.OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Exchange, &m.RoutingKey, &m.MessageID, &m.CorrelationID, &m.ContentType, &m.Body, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = m.CreatedAt.UTC()
+		msgs = append(msgs, m)
+	}
+
+	return msgs, rows.Err()
+}
+
+// MarkSent marks the messages with the given ids as sent, removing them from the pending ones.
+func (s *Storage) MarkSent(ids ...uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []interface{}{time.Now().UTC()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.Exec(
+		s.rebind("UPDATE outbox SET sent_at = ? WHERE id IN (?"+strings.Repeat(", ?", len(ids)-1)+")"), args...,
+	)
+	return err
+}
+
+// appendMessage inserts the message in the transaction of the user mutation.
+func (s *Storage) appendMessage(ctx context.Context, tx *dbsql.Tx, m srv.OutboxMessage) error {
+	_, err := tx.ExecContext(ctx,
+		s.rebind("INSERT INTO outbox (exchange, routing_key, message_id, correlation_id, content_type, body, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		m.Exchange, m.RoutingKey, m.MessageID, m.CorrelationID, m.ContentType, m.Body, m.CreatedAt,
+	)
+	return err
+}
@@ -0,0 +1,147 @@
+package sql
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer"
+	"github.com/rafaeljesus/srv-consumer/storage/storetest"
+)
+
+func TestUserStore(t *testing.T) {
+	t.Run(SQLite, func(t *testing.T) {
+		storetest.RunUserStoreTests(t, func(t *testing.T) srv.UserStore {
+			return open(t, "sqlite://"+filepath.Join(t.TempDir(), "users.db"))
+		})
+	})
+	t.Run(Postgres, func(t *testing.T) {
+		dsn := os.Getenv("POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("POSTGRES_DSN not set")
+		}
+		storetest.RunUserStoreTests(t, func(t *testing.T) srv.UserStore {
+			s := open(t, dsn)
+			truncate(t, s)
+			return s
+		})
+	})
+}
+
+func TestStorage(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, string, func(*testing.T) string)
+	}{
+		{
+			"apply migrations once",
+			testApplyMigrationsOnce,
+		},
+		{
+			"migrate replicas starting together",
+			testMigrateConcurrently,
+		},
+		{
+			"rebind placeholders of dialect",
+			testRebindPlaceholders,
+		},
+	}
+
+	dsns := map[string]func(*testing.T) string{
+		SQLite: func(t *testing.T) string {
+			return "sqlite://" + filepath.Join(t.TempDir(), "users.db")
+		},
+		Postgres: func(t *testing.T) string {
+			dsn := os.Getenv("POSTGRES_DSN")
+			if dsn == "" {
+				t.Skip("POSTGRES_DSN not set")
+			}
+			return dsn
+		},
+	}
+
+	for dialect, dsn := range dsns {
+		for _, test := range tests {
+			t.Run(dialect+"/"+test.scenario, func(t *testing.T) {
+				test.function(t, dialect, dsn)
+			})
+		}
+	}
+}
+
+func testApplyMigrationsOnce(t *testing.T, dialect string, dsn func(*testing.T) string) {
+	source := dsn(t)
+	s := open(t, source)
+	open(t, source)
+
+	ms, err := load(dialect)
+	if err != nil {
+		t.Fatalf("expected to load migrations: %v", err)
+	}
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&n); err != nil {
+		t.Fatalf("expected to count migrations: %v", err)
+	}
+	if n != len(ms) {
+		t.Fatalf("expected migrations to be applied once: %d", n)
+	}
+}
+
+func testMigrateConcurrently(t *testing.T, dialect string, dsn func(*testing.T) string) {
+	if dialect != Postgres {
+		t.Skip("only postgres serializes the migrations with an advisory lock")
+	}
+	source := dsn(t)
+	if _, err := open(t, source).db.Exec("DROP TABLE IF EXISTS users, user_changes, outbox, schema_migrations CASCADE"); err != nil {
+		t.Fatalf("expected to drop tables: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := New(source)
+			if err == nil {
+				s.Close()
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("expected replicas to wait for the migrations of one another: %v", err)
+		}
+	}
+}
+
+func testRebindPlaceholders(t *testing.T, dialect string, _ func(*testing.T) string) {
+	want := map[string]string{
+		SQLite:   "UPDATE users SET email = ? WHERE id = ?",
+		Postgres: "UPDATE users SET email = $1 WHERE id = $2",
+	}
+	s := &Storage{dialect: dialect}
+	if got := s.rebind("UPDATE users SET email = ? WHERE id = ?"); got != want[dialect] {
+		t.Fatalf("unexpected query: %s", got)
+	}
+}
+
+func open(t *testing.T, dsn string) *Storage {
+	s, err := New(dsn)
+	if err != nil {
+		t.Fatalf("expected to open storage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// truncate empties the tables of the postgres storage, along with their sequences.
+func truncate(t *testing.T, s *Storage) {
+	if _, err := s.db.Exec("TRUNCATE users, user_changes, outbox RESTART IDENTITY"); err != nil {
+		t.Fatalf("expected to truncate tables: %v", err)
+	}
+}
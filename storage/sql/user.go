@@ -0,0 +1,322 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+// uniqueViolation is the postgres SQLSTATE of unique constraint violations.
+const uniqueViolation = "23505"
+
+type (
+	// scope is recorded along with the mutations of a scoped storage.
+	scope struct {
+		cause  srv.Cause
+		causes []srv.Cause
+		emit   srv.Emitter
+	}
+
+	// scoped is the storage recording its scope along with its mutations.
+	scoped struct {
+		*Storage
+		scope
+	}
+
+	// querier is implemented by both the database and its transactions.
+	querier interface {
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *dbsql.Row
+	}
+)
+
+// Add a new user to the store.
+func (s *Storage) Add(ctx context.Context, user *srv.User) error {
+	return s.add(ctx, user, scope{})
+}
+
+// Save a user to the store.
+func (s *Storage) Save(ctx context.Context, user *srv.User) error {
+	return s.save(ctx, user, scope{})
+}
+
+// Find returns the user with the given id.
+func (s *Storage) Find(ctx context.Context, id uint) (*srv.User, error) {
+	return s.find(ctx, s.db, id)
+}
+
+// History returns the changes of the user with the given id, oldest first.
+func (s *Storage) History(userID uint) ([]srv.Change, error) {
+	rows, err := s.db.Query(
+		s.rebind("SELECT revision, diff, message_id, routing_key, changed_at FROM user_changes WHERE user_id = ? ORDER BY id"), userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []srv.Change
+	for rows.Next() {
+		change := srv.Change{UserID: userID}
+		var diff []byte
+		if err := rows.Scan(&change.Revision, &diff, &change.MessageID, &change.RoutingKey, &change.Time); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(diff, &change.Diff); err != nil {
+			return nil, err
+		}
+		change.Time = change.Time.UTC()
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// AddMany adds the users in a single transaction, returning the error of each one, nil when added.
+func (s *Storage) AddMany(ctx context.Context, users []*srv.User) []error {
+	return s.many(ctx, users, scope{}, s.addTx)
+}
+
+// SaveMany saves the users in a single transaction, returning the error of each one, nil when saved.
+func (s *Storage) SaveMany(ctx context.Context, users []*srv.User) []error {
+	return s.many(ctx, users, scope{}, s.saveTx)
+}
+
+// WithCause returns the storage recording c as the cause of its mutations.
+func (s *Storage) WithCause(c srv.Cause) srv.UserStore {
+	return &scoped{s, scope{cause: c}}
+}
+
+// WithCauses returns the storage recording causes[i] as the cause of the mutation of the i-th user of its bulk operations.
+func (s *Storage) WithCauses(causes []srv.Cause) srv.UserStore {
+	return &scoped{s, scope{causes: causes}}
+}
+
+// WithOutbox returns the storage recording the messages emitted for its mutations.
+func (s *Storage) WithOutbox(emit srv.Emitter) srv.UserStore {
+	return &scoped{s, scope{emit: emit}}
+}
+
+// Add a new user to the store.
+func (s *scoped) Add(ctx context.Context, user *srv.User) error {
+	return s.add(ctx, user, s.scope)
+}
+
+// Save a user to the store.
+func (s *scoped) Save(ctx context.Context, user *srv.User) error {
+	return s.save(ctx, user, s.scope)
+}
+
+// AddMany adds the users in a single transaction, returning the error of each one, nil when added.
+func (s *scoped) AddMany(ctx context.Context, users []*srv.User) []error {
+	return s.many(ctx, users, s.scope, s.addTx)
+}
+
+// SaveMany saves the users in a single transaction, returning the error of each one, nil when saved.
+func (s *scoped) SaveMany(ctx context.Context, users []*srv.User) []error {
+	return s.many(ctx, users, s.scope, s.saveTx)
+}
+
+// WithCause returns the storage recording c as the cause of its mutations.
+func (s *scoped) WithCause(c srv.Cause) srv.UserStore {
+	return &scoped{s.Storage, scope{cause: c, emit: s.emit}}
+}
+
+// WithCauses returns the storage recording causes[i] as the cause of the mutation of the i-th user of its bulk operations.
+func (s *scoped) WithCauses(causes []srv.Cause) srv.UserStore {
+	return &scoped{s.Storage, scope{causes: causes, emit: s.emit}}
+}
+
+// WithOutbox returns the storage recording the messages emitted for its mutations.
+func (s *scoped) WithOutbox(emit srv.Emitter) srv.UserStore {
+	return &scoped{s.Storage, scope{cause: s.cause, causes: s.causes, emit: emit}}
+}
+
+// at returns the scope of the i-th user of a bulk operation.
+func (sc scope) at(i int) scope {
+	if i < len(sc.causes) {
+		sc.cause = sc.causes[i]
+	}
+
+	return sc
+}
+
+func (s *Storage) add(ctx context.Context, user *srv.User, sc scope) error {
+	return s.many(ctx, []*srv.User{user}, sc, s.addTx)[0]
+}
+
+func (s *Storage) save(ctx context.Context, user *srv.User, sc scope) error {
+	return s.many(ctx, []*srv.User{user}, sc, s.saveTx)[0]
+}
+
+// many mutates the users with fn in a single transaction, each one within a savepoint.
+// The users failing with a store error, such as srv.ErrConflict, are rolled back to their
+// savepoint and skipped while any other error fails them all. The id and revision of the
+// mutated users are only updated once the transaction is committed.
+func (s *Storage) many(ctx context.Context, users []*srv.User, sc scope, fn func(ctx context.Context, tx *dbsql.Tx, user srv.User, sc scope) (srv.User, error)) []error {
+	errs := make([]error, len(users))
+	fail := func(err error) []error {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback()
+
+	mutated := make([]srv.User, len(users))
+	for i, user := range users {
+		// a failed statement aborts the whole postgres transaction unless rolled back to a savepoint.
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT item"); err != nil {
+			return fail(err)
+		}
+
+		u, err := fn(ctx, tx, *user, sc.at(i))
+		switch err {
+		case nil:
+			mutated[i] = u
+		case srv.ErrConflict, srv.ErrNotFound, srv.ErrVersionMismatch:
+			errs[i] = err
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT item"); err != nil {
+				return fail(err)
+			}
+		default:
+			return fail(err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT item"); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fail(mapError(err))
+	}
+
+	for i, user := range users {
+		if errs[i] == nil {
+			user.ID, user.Revision = mutated[i].ID, mutated[i].Revision
+		}
+	}
+
+	return errs
+}
+
+func (s *Storage) addTx(ctx context.Context, tx *dbsql.Tx, u srv.User, sc scope) (srv.User, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx,
+		s.rebind("INSERT INTO users (username, email, status, revision) VALUES (?, ?, ?, 1) RETURNING id"),
+		u.Username, u.Email, u.Status,
+	).Scan(&id)
+	if err != nil {
+		return u, mapError(err)
+	}
+
+	u.ID = uint(id)
+	u.Revision = 1
+	return u, s.record(ctx, tx, nil, &u, sc)
+}
+
+func (s *Storage) saveTx(ctx context.Context, tx *dbsql.Tx, u srv.User, sc scope) (srv.User, error) {
+	old, err := s.find(ctx, tx, u.ID)
+	if err != nil {
+		return u, err
+	}
+	if old.Revision != u.Revision {
+		return u, srv.ErrVersionMismatch
+	}
+
+	res, err := tx.ExecContext(ctx,
+		s.rebind("UPDATE users SET username = ?, email = ?, status = ?, revision = revision + 1 WHERE id = ? AND revision = ?"),
+		u.Username, u.Email, u.Status, u.ID, u.Revision,
+	)
+	if err != nil {
+		return u, mapError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return u, err
+	}
+	if n == 0 {
+		// it was saved with another revision since it was read.
+		return u, srv.ErrVersionMismatch
+	}
+
+	u.Revision++
+	return u, s.record(ctx, tx, old, &u, sc)
+}
+
+func (s *Storage) find(ctx context.Context, q querier, id uint) (*srv.User, error) {
+	user := new(srv.User)
+	err := q.QueryRowContext(ctx,
+		s.rebind("SELECT id, username, email, status, revision FROM users WHERE id = ?"), id,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Status, &user.Revision)
+	if errors.Is(err, dbsql.ErrNoRows) {
+		return nil, srv.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// record inserts the change of the user mutated from before and its emitted messages
+// in the transaction of the mutation.
+func (s *Storage) record(ctx context.Context, tx *dbsql.Tx, before, user *srv.User, sc scope) error {
+	now := time.Now()
+	change := srv.NewChange(before, user, sc.cause, now)
+	msgs, err := sc.emit.Emit(*user, change)
+	if err != nil {
+		return err
+	}
+
+	if err := s.appendChange(ctx, tx, change); err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		m.CreatedAt = now.UTC()
+		if err := s.appendMessage(ctx, tx, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendChange inserts the change in the transaction of the user mutation.
+func (s *Storage) appendChange(ctx context.Context, tx *dbsql.Tx, change srv.Change) error {
+	diff, err := json.Marshal(change.Diff)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		s.rebind("INSERT INTO user_changes (user_id, revision, diff, message_id, routing_key, changed_at) VALUES (?, ?, ?, ?, ?, ?)"),
+		change.UserID, change.Revision, string(diff), change.MessageID, change.RoutingKey, change.Time,
+	)
+	return err
+}
+
+// mapError maps unique constraint violations to srv.ErrConflict,
+// without depending on the driver error types.
+func mapError(err error) error {
+	var e interface{ SQLState() string }
+	if errors.As(err, &e) && e.SQLState() == uniqueViolation {
+		return srv.ErrConflict
+	}
+	if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return srv.ErrConflict
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+var (
+	// ErrUnknownDriver is returned when no driver is registered for the DSN scheme.
+	ErrUnknownDriver = errors.New("unknown storage driver")
+
+	mu      sync.RWMutex
+	drivers = make(map[string]Driver)
+)
+
+type (
+	// Store is a user store which keeps an audit log and an outbox, can check it is reachable and be closed.
+	Store interface {
+		srv.UserStore
+		srv.AuditLog
+		srv.Outbox
+		srv.Pinger
+		io.Closer
+	}
+
+	// Driver opens the store of a DSN.
+	Driver func(dsn string) (Store, error)
+)
+
+// Register makes a driver available for the given DSN scheme.
+// It panics when a driver is already registered for the scheme.
+func Register(scheme string, d Driver) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := drivers[scheme]; ok {
+		panic("storage: driver already registered for scheme " + scheme)
+	}
+	drivers[scheme] = d
+}
+
+// Drivers returns the registered DSN schemes, sorted.
+func Drivers() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Open opens the store of dsn with the driver registered for its scheme,
+// e.g. memory://, file:///var/lib/srv-consumer or postgres://localhost/users.
+func Open(dsn string) (Store, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	d, ok := drivers[u.Scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, u.Scheme)
+	}
+
+	return d(dsn)
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+type store struct{}
+
+func (store) Add(ctx context.Context, user *srv.User) error  { return nil }
+func (store) Save(ctx context.Context, user *srv.User) error { return nil }
+func (store) AddMany(ctx context.Context, users []*srv.User) []error {
+	return make([]error, len(users))
+}
+func (store) SaveMany(ctx context.Context, users []*srv.User) []error {
+	return make([]error, len(users))
+}
+func (store) Find(ctx context.Context, id uint) (*srv.User, error) { return nil, srv.ErrNotFound }
+func (store) History(id uint) ([]srv.Change, error)                { return nil, nil }
+func (s store) WithCause(c srv.Cause) srv.UserStore                { return s }
+func (s store) WithCauses(c []srv.Cause) srv.UserStore             { return s }
+func (s store) WithOutbox(e srv.Emitter) srv.UserStore             { return s }
+func (store) Pending(limit int) ([]srv.OutboxMessage, error)       { return nil, nil }
+func (store) MarkSent(ids ...uint64) error                         { return nil }
+func (store) Ping(ctx context.Context) error                       { return nil }
+func (store) Close() error                                         { return nil }
+
+func TestStorage(t *testing.T) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T)
+	}{
+		{
+			"open registered driver",
+			testOpenRegisteredDriver,
+		},
+		{
+			"fail to open unknown driver",
+			testFailToOpenUnknownDriver,
+		},
+		{
+			"panic on duplicated driver",
+			testPanicOnDuplicatedDriver,
+		},
+	}
+
+	Register("test", func(dsn string) (Store, error) { return store{}, nil })
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t)
+		})
+	}
+}
+
+func testOpenRegisteredDriver(t *testing.T) {
+	s, err := Open("test://localhost")
+	if err != nil {
+		t.Fatalf("expected to open store: %v", err)
+	}
+	if s == nil {
+		t.Fatal("expected store")
+	}
+	if len(Drivers()) != 1 || Drivers()[0] != "test" {
+		t.Fatalf("unexpected drivers: %v", Drivers())
+	}
+}
+
+func testFailToOpenUnknownDriver(t *testing.T) {
+	if _, err := Open("mongodb://localhost"); !errors.Is(err, ErrUnknownDriver) {
+		t.Fatalf("expected to have ErrUnknownDriver: %v", err)
+	}
+}
+
+func testPanicOnDuplicatedDriver(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Register() to panic")
+		}
+	}()
+	Register("test", func(dsn string) (Store, error) { return store{}, nil })
+}
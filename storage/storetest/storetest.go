@@ -0,0 +1,645 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rafaeljesus/srv-consumer"
+)
+
+// Factory returns a new empty store, cleaned up when the test finishes.
+type Factory func(t *testing.T) srv.UserStore
+
+// RunUserStoreTests runs the conformance tests every srv.UserStore implementation
+// must pass against the stores returned by factory.
+func RunUserStoreTests(t *testing.T, factory Factory) {
+	tests := []struct {
+		scenario string
+		function func(*testing.T, srv.UserStore)
+	}{
+		{
+			"add user assigns id",
+			testAddAssignsID,
+		},
+		{
+			"add users assigns distinct ids",
+			testAddAssignsDistinctIDs,
+		},
+		{
+			"fail to add duplicated username",
+			testFailToAddDuplicatedUsername,
+		},
+		{
+			"keep conflict of a single add",
+			testKeepConflictOfSingleAdd,
+		},
+		{
+			"save user",
+			testSave,
+		},
+		{
+			"save user releases previous username",
+			testSaveReleasesUsername,
+		},
+		{
+			"fail to save onto another user's username",
+			testFailToSaveOntoAnotherUsername,
+		},
+		{
+			"fail to save unknown user",
+			testFailToSaveUnknown,
+		},
+		{
+			"fail to save stale revision",
+			testFailToSaveStaleRevision,
+		},
+		{
+			"add many users",
+			testAddMany,
+		},
+		{
+			"save many users",
+			testSaveMany,
+		},
+		{
+			"record cause of each bulk mutation",
+			testRecordCauseOfEachBulkMutation,
+		},
+		{
+			"find user",
+			testFind,
+		},
+		{
+			"fail to find unknown user",
+			testFailToFindUnknown,
+		},
+		{
+			"record changes history",
+			testRecordHistory,
+		},
+		{
+			"history of unknown user is empty",
+			testHistoryOfUnknownIsEmpty,
+		},
+		{
+			"record emitted messages",
+			testRecordEmittedMessages,
+		},
+		{
+			"fail to mutate when messages are not emitted",
+			testFailToMutateWhenNotEmitted,
+		},
+		{
+			"give up once context is done",
+			testGiveUpOnceContextIsDone,
+		},
+		{
+			"add concurrently",
+			testAddConcurrently,
+		},
+		{
+			"add duplicated username concurrently",
+			testAddDuplicatedConcurrently,
+		},
+		{
+			"save same revision concurrently",
+			testSaveConcurrently,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.function(t, factory(t))
+		})
+	}
+}
+
+func testAddAssignsID(t *testing.T, s srv.UserStore) {
+	user := &srv.User{Username: "foo", Email: "foo@bar.com", Status: "active"}
+	if err := s.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	if user.ID == 0 {
+		t.Fatal("expected user id to be assigned")
+	}
+	if user.Revision != 1 {
+		t.Fatalf("unexpected revision: %d", user.Revision)
+	}
+}
+
+func testAddAssignsDistinctIDs(t *testing.T, s srv.UserStore) {
+	foo, bar := &srv.User{Username: "foo"}, &srv.User{Username: "bar"}
+	if err := s.Add(context.Background(), foo); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	if err := s.Add(context.Background(), bar); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	if foo.ID == bar.ID {
+		t.Fatalf("expected distinct ids: %d", foo.ID)
+	}
+}
+
+func testFailToAddDuplicatedUsername(t *testing.T, s srv.UserStore) {
+	if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "foo", Email: "other@bar.com"}); err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+}
+
+func testKeepConflictOfSingleAdd(t *testing.T, s srv.UserStore) {
+	if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	// a conflict must not abort the transaction the add runs in.
+	for i := 0; i < 2; i++ {
+		if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != srv.ErrConflict {
+			t.Fatalf("expected to have ErrConflict: %v", err)
+		}
+		if errs := s.AddMany(context.Background(), []*srv.User{{Username: "foo"}}); errs[0] != srv.ErrConflict {
+			t.Fatalf("expected to have ErrConflict: %v", errs)
+		}
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "bar"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+}
+
+func testSave(t *testing.T, s srv.UserStore) {
+	user := &srv.User{Username: "foo", Email: "foo@bar.com"}
+	if err := s.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	saved := &srv.User{ID: user.ID, Username: "foo", Email: "bar@bar.com", Status: "inactive", Revision: user.Revision}
+	if err := s.Save(context.Background(), saved); err != nil {
+		t.Fatalf("expected to save user: %v", err)
+	}
+	if saved.Revision != 2 {
+		t.Fatalf("unexpected revision: %d", saved.Revision)
+	}
+
+	found, err := s.Find(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if *found != *saved {
+		t.Fatalf("unexpected user: %+v", found)
+	}
+}
+
+func testSaveReleasesUsername(t *testing.T, s srv.UserStore) {
+	user := &srv.User{Username: "foo"}
+	if err := s.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	if err := s.Save(context.Background(), &srv.User{ID: user.ID, Username: "bar", Revision: user.Revision}); err != nil {
+		t.Fatalf("expected to save user: %v", err)
+	}
+
+	if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != nil {
+		t.Fatalf("expected previous username to be available: %v", err)
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "bar"}); err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+}
+
+func testFailToSaveOntoAnotherUsername(t *testing.T, s srv.UserStore) {
+	foo, bar := &srv.User{Username: "foo"}, &srv.User{Username: "bar"}
+	for _, user := range []*srv.User{foo, bar} {
+		if err := s.Add(context.Background(), user); err != nil {
+			t.Fatalf("expected to add user: %v", err)
+		}
+	}
+
+	renamed := *bar
+	renamed.Username = "foo"
+	if err := s.Save(context.Background(), &renamed); err != srv.ErrConflict {
+		t.Fatalf("expected to have ErrConflict: %v", err)
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != srv.ErrConflict {
+		t.Fatalf("expected username to still be taken: %v", err)
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "bar"}); err != srv.ErrConflict {
+		t.Fatalf("expected username to still be taken: %v", err)
+	}
+	found, err := s.Find(context.Background(), bar.ID)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if found.Username != "bar" || found.Revision != bar.Revision {
+		t.Fatalf("expected user to not be saved: %+v", found)
+	}
+}
+
+func testFailToSaveUnknown(t *testing.T, s srv.UserStore) {
+	if err := s.Save(context.Background(), &srv.User{ID: 42, Username: "foo"}); err != srv.ErrNotFound {
+		t.Fatalf("expected to have ErrNotFound: %v", err)
+	}
+}
+
+func testFailToSaveStaleRevision(t *testing.T, s srv.UserStore) {
+	user := &srv.User{Username: "foo"}
+	if err := s.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	stale := *user
+	if err := s.Save(context.Background(), user); err != nil {
+		t.Fatalf("expected to save user: %v", err)
+	}
+	if err := s.Save(context.Background(), &stale); err != srv.ErrVersionMismatch {
+		t.Fatalf("expected to have ErrVersionMismatch: %v", err)
+	}
+}
+
+func testAddMany(t *testing.T, s srv.UserStore) {
+	if err := s.Add(context.Background(), &srv.User{Username: "bar"}); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	users := []*srv.User{{Username: "foo"}, {Username: "bar"}, {Username: "baz"}, {Username: "foo"}}
+	errs := s.AddMany(context.Background(), users)
+	want := []error{nil, srv.ErrConflict, nil, srv.ErrConflict}
+	if !reflect.DeepEqual(errs, want) {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if users[1].ID != 0 || users[3].ID != 0 {
+		t.Fatalf("expected conflicting users to not be assigned an id: %+v", users)
+	}
+	for _, user := range []*srv.User{users[0], users[2]} {
+		found, err := s.Find(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("expected to find user: %v", err)
+		}
+		if found.Username != user.Username || found.Revision != 1 {
+			t.Fatalf("unexpected user: %+v", found)
+		}
+	}
+}
+
+func testSaveMany(t *testing.T, s srv.UserStore) {
+	foo, bar := &srv.User{Username: "foo"}, &srv.User{Username: "bar"}
+	if errs := s.AddMany(context.Background(), []*srv.User{foo, bar}); errs[0] != nil || errs[1] != nil {
+		t.Fatalf("expected to add users: %v", errs)
+	}
+
+	foo.Email = "foo@bar.com"
+	stale := &srv.User{ID: bar.ID, Username: "bar", Revision: bar.Revision + 1}
+	unknown := &srv.User{ID: 42, Username: "baz"}
+	errs := s.SaveMany(context.Background(), []*srv.User{foo, stale, unknown})
+	want := []error{nil, srv.ErrVersionMismatch, srv.ErrNotFound}
+	if !reflect.DeepEqual(errs, want) {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if foo.Revision != 2 || stale.Revision != 2 {
+		t.Fatalf("expected revision of saved users only to be incremented: %+v %+v", foo, stale)
+	}
+
+	found, err := s.Find(context.Background(), foo.ID)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if found.Email != "foo@bar.com" || found.Revision != 2 {
+		t.Fatalf("unexpected user: %+v", found)
+	}
+}
+
+func testRecordCauseOfEachBulkMutation(t *testing.T, s srv.UserStore) {
+	log := auditLog(t, s)
+	causes := []srv.Cause{{MessageID: "1"}, {MessageID: "2"}}
+	users := []*srv.User{{Username: "foo"}, {Username: "bar"}}
+	if errs := log.WithCauses(causes).AddMany(context.Background(), users); errs[0] != nil || errs[1] != nil {
+		t.Fatalf("expected to add users: %v", errs)
+	}
+
+	for i, user := range users {
+		changes, err := log.History(user.ID)
+		if err != nil {
+			t.Fatalf("expected to find history: %v", err)
+		}
+		if len(changes) != 1 || changes[0].Cause != causes[i] {
+			t.Fatalf("expected change caused by its own message: %+v", changes)
+		}
+	}
+}
+
+func testFind(t *testing.T, s srv.UserStore) {
+	user := &srv.User{Username: "foo", Email: "foo@bar.com", Status: "active"}
+	if err := s.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	found, err := s.Find(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if *found != *user {
+		t.Fatalf("unexpected user: %+v", found)
+	}
+
+	found.Email = "bar@bar.com"
+	if again, _ := s.Find(context.Background(), user.ID); again.Email != "foo@bar.com" {
+		t.Fatal("expected found user to be a copy")
+	}
+}
+
+func testFailToFindUnknown(t *testing.T, s srv.UserStore) {
+	if _, err := s.Find(context.Background(), 42); err != srv.ErrNotFound {
+		t.Fatalf("expected to have ErrNotFound: %v", err)
+	}
+}
+
+func testRecordHistory(t *testing.T, s srv.UserStore) {
+	log := auditLog(t, s)
+	created := srv.Cause{MessageID: "1", RoutingKey: "user.created"}
+	user := &srv.User{Username: "foo", Email: "foo@bar.com"}
+	if err := log.WithCause(created).Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	changed := srv.Cause{MessageID: "2", RoutingKey: "user.email.changed"}
+	stale := *user
+	user.Email = "bar@bar.com"
+	if err := log.WithCause(changed).Save(context.Background(), user); err != nil {
+		t.Fatalf("expected to save user: %v", err)
+	}
+	if err := log.WithCause(changed).Save(context.Background(), &stale); err != srv.ErrVersionMismatch {
+		t.Fatalf("expected to have ErrVersionMismatch: %v", err)
+	}
+
+	changes, err := log.History(user.ID)
+	if err != nil {
+		t.Fatalf("expected to find history: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected a change per mutation: %+v", changes)
+	}
+
+	want := []srv.Change{
+		{
+			UserID:   user.ID,
+			Revision: 1,
+			Diff: []srv.FieldChange{
+				{Field: "username", To: "foo"},
+				{Field: "email", To: "foo@bar.com"},
+			},
+			Cause: created,
+		},
+		{
+			UserID:   user.ID,
+			Revision: 2,
+			Diff:     []srv.FieldChange{{Field: "email", From: "foo@bar.com", To: "bar@bar.com"}},
+			Cause:    changed,
+		},
+	}
+	for i, change := range changes {
+		if change.Time.IsZero() {
+			t.Fatalf("expected change time: %+v", change)
+		}
+		change.Time = time.Time{}
+		if !reflect.DeepEqual(change, want[i]) {
+			t.Fatalf("unexpected change: %+v", change)
+		}
+	}
+}
+
+func testHistoryOfUnknownIsEmpty(t *testing.T, s srv.UserStore) {
+	changes, err := auditLog(t, s).History(42)
+	if err != nil {
+		t.Fatalf("expected to find history: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("unexpected changes: %+v", changes)
+	}
+}
+
+func testRecordEmittedMessages(t *testing.T, s srv.UserStore) {
+	outbox := outbox(t, s)
+	emit := func(user srv.User, change srv.Change) ([]srv.OutboxMessage, error) {
+		return []srv.OutboxMessage{{
+			Exchange:      "users",
+			RoutingKey:    "user.projection.updated",
+			CorrelationID: change.MessageID,
+			Body:          []byte(user.Email),
+		}}, nil
+	}
+
+	store := outbox.WithOutbox(emit).(srv.AuditLog).WithCause(srv.Cause{MessageID: "1"})
+	user := &srv.User{Username: "foo", Email: "foo@bar.com"}
+	if err := store.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+	user.Email = "bar@bar.com"
+	if err := store.Save(context.Background(), user); err != nil {
+		t.Fatalf("expected to save user: %v", err)
+	}
+	if err := s.Save(context.Background(), &srv.User{ID: user.ID, Username: "foo", Revision: user.Revision}); err != nil {
+		t.Fatalf("expected to save user: %v", err)
+	}
+
+	msgs, err := outbox.Pending(10)
+	if err != nil {
+		t.Fatalf("expected to find pending messages: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected a message per emitting mutation: %+v", msgs)
+	}
+	for i, email := range []string{"foo@bar.com", "bar@bar.com"} {
+		m := msgs[i]
+		if m.ID == 0 || m.CreatedAt.IsZero() {
+			t.Fatalf("expected message id and creation time: %+v", m)
+		}
+		if m.RoutingKey != "user.projection.updated" || m.CorrelationID != "1" || string(m.Body) != email {
+			t.Fatalf("unexpected message: %+v", m)
+		}
+	}
+	if msgs[0].ID >= msgs[1].ID {
+		t.Fatalf("expected messages oldest first: %+v", msgs)
+	}
+
+	if msgs, _ := outbox.Pending(1); len(msgs) != 1 {
+		t.Fatalf("expected pending messages to be limited: %+v", msgs)
+	}
+	if err := outbox.MarkSent(msgs[0].ID); err != nil {
+		t.Fatalf("expected to mark message sent: %v", err)
+	}
+	pending, err := outbox.Pending(10)
+	if err != nil {
+		t.Fatalf("expected to find pending messages: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != msgs[1].ID {
+		t.Fatalf("expected sent message to not be pending: %+v", pending)
+	}
+}
+
+func testFailToMutateWhenNotEmitted(t *testing.T, s srv.UserStore) {
+	outbox := outbox(t, s)
+	errEmit := errors.New("emit error")
+	emit := func(user srv.User, change srv.Change) ([]srv.OutboxMessage, error) {
+		return nil, errEmit
+	}
+
+	user := &srv.User{Username: "foo"}
+	if err := outbox.WithOutbox(emit).Add(context.Background(), user); err != errEmit {
+		t.Fatalf("expected to have emit error: %v", err)
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "foo"}); err != nil {
+		t.Fatalf("expected user to not be added: %v", err)
+	}
+}
+
+func testGiveUpOnceContextIsDone(t *testing.T, s srv.UserStore) {
+	user := &srv.User{Username: "foo"}
+	if err := s.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Add(ctx, &srv.User{Username: "bar"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected to have context.Canceled: %v", err)
+	}
+	user.Email = "foo@bar.com"
+	if err := s.Save(ctx, user); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected to have context.Canceled: %v", err)
+	}
+	if _, err := s.Find(ctx, user.ID); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected to have context.Canceled: %v", err)
+	}
+	if errs := s.AddMany(ctx, []*srv.User{{Username: "bar"}}); !errors.Is(errs[0], context.Canceled) {
+		t.Fatalf("expected to have context.Canceled: %v", errs)
+	}
+
+	found, err := s.Find(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("expected to find user: %v", err)
+	}
+	if found.Email != "" || found.Revision != 1 {
+		t.Fatalf("expected user to not be saved: %+v", found)
+	}
+	if err := s.Add(context.Background(), &srv.User{Username: "bar"}); err != nil {
+		t.Fatalf("expected user to not be added: %v", err)
+	}
+}
+
+func testAddConcurrently(t *testing.T, s srv.UserStore) {
+	const n = 20
+	ids := make(chan uint, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			user := &srv.User{Username: fmt.Sprintf("user%d", i)}
+			if err := s.Add(context.Background(), user); err != nil {
+				t.Errorf("expected to add user: %v", err)
+				return
+			}
+			ids <- user.ID
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[uint]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("expected distinct ids: %d assigned twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func testAddDuplicatedConcurrently(t *testing.T, s srv.UserStore) {
+	const n = 20
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.Add(context.Background(), &srv.User{Username: "foo"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	added := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			added++
+		case srv.ErrConflict:
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if added != 1 {
+		t.Fatalf("expected a single user to be added: %d", added)
+	}
+}
+
+func testSaveConcurrently(t *testing.T, s srv.UserStore) {
+	user := &srv.User{Username: "foo"}
+	if err := s.Add(context.Background(), user); err != nil {
+		t.Fatalf("expected to add user: %v", err)
+	}
+
+	const n = 20
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			saved := &srv.User{ID: user.ID, Username: "foo", Status: fmt.Sprintf("status%d", i), Revision: user.Revision}
+			errs <- s.Save(context.Background(), saved)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	saved := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			saved++
+		case srv.ErrVersionMismatch:
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if saved != 1 {
+		t.Fatalf("expected a single save to win: %d", saved)
+	}
+}
+
+// auditLog returns the audit log of s, skipping the test when s keeps none.
+func auditLog(t *testing.T, s srv.UserStore) srv.AuditLog {
+	log, ok := s.(srv.AuditLog)
+	if !ok {
+		t.Skip("store keeps no audit log")
+	}
+
+	return log
+}
+
+// outbox returns the outbox of s, skipping the test when s has none.
+func outbox(t *testing.T, s srv.UserStore) srv.Outbox {
+	outbox, ok := s.(srv.Outbox)
+	if !ok {
+		t.Skip("store has no outbox")
+	}
+
+	return outbox
+}
@@ -1,5 +1,7 @@
 package srv
 
+import "context"
+
 type (
 	// User is the model struct which represents a user.
 	User struct {
@@ -7,13 +9,29 @@ type (
 		Username string `json:"username"`
 		Email    string `json:"email"`
 		Status   string `json:"status"`
+		// Revision is incremented on every save, starting at 1 when the user is added.
+		Revision uint64 `json:"revision,omitempty"`
 	}
 
 	// UserStore contains methods for managing users in a storage.
+	// The methods give up with the context error once ctx is done.
 	UserStore interface {
 		// Add a new user to the store.
-		Add(user *User) error
-		// Save a user to the store.
-		Save(user *User) error
+		Add(ctx context.Context, user *User) error
+		// Save a user to the store, failing with ErrVersionMismatch unless
+		// its revision is the stored one. The user revision is then incremented.
+		Save(ctx context.Context, user *User) error
+		// Find returns the user with the given id.
+		Find(ctx context.Context, id uint) (*User, error)
+		// AddMany adds the users at once, returning the error of each one, nil when added.
+		AddMany(ctx context.Context, users []*User) []error
+		// SaveMany saves the users at once as Save does, returning the error of each one, nil when saved.
+		SaveMany(ctx context.Context, users []*User) []error
+	}
+
+	// Pinger is implemented by stores which can check they are reachable.
+	Pinger interface {
+		// Ping returns an error when the store is unreachable.
+		Ping(ctx context.Context) error
 	}
 )